@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"github.com/csyezheng/ip-scanner/common"
+	"os"
+	"strings"
+)
+
+// Exit codes of ip_scanner. A scan exits with exitNoneFound if a site found no IP and
+// with exitHostsFailed if the hosts file could not be written.
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitUsage       = 2
+	exitNoneFound   = 3
+	exitHostsFailed = 4
+)
+
+// command is a subcommand of ip_scanner.
+type command struct {
+	name    string
+	args    string // synopsis of the arguments after the options
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"scan", "", "Scan the IP ranges of a site for the fastest IPs (default)", runScan},
+		{"fetch", "", "Fetch the latest IP ranges of a site into its IPRangesFile", runFetch},
+		{"hosts", "apply|remove|restore|backups", "Point the site's domains to the best IP in the hosts file, or undo it", runHosts},
+		{"ranges", "<operation> [files...]", "Count, merge, subtract, split and sample IP ranges files", runRanges},
+		{"results", "", "Show the IPs found by the last scan of a site", runResults},
+		{"export", "", "Export the best IPs of the last scan for local resolvers and proxy clients", runExport},
+		{"daemon", "", "Keep the hosts file and exports pointed to healthy IPs, scanning again when needed", runDaemon},
+		{"dns", "", "Answer DNS queries of the sites' domains with their best IPs, forward the others", runDNS},
+		{"proxy", "", "Forward TLS connections to the best IP of the site of their server name", runProxy},
+		{"serve", "", "Serve an HTTP API and dashboard of the scans and results, and start scans", runServe},
+		{"config", "validate", "Check the configuration file", runConfig},
+	}
+}
+
+// Main runs ip_scanner with the command line arguments and returns the exit code.
+// Without a command it scans, so "ip_scanner -site Cloudflare" keeps working.
+func Main(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && !isHelp(args[0]) {
+		return runScan(args)
+	}
+	name := args[0]
+	if isHelp(name) || name == "help" {
+		if len(args) > 1 {
+			return Main([]string{args[1], "-h"})
+		}
+		printUsage()
+		return exitOK
+	}
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "ip_scanner: unknown command %q\n\n", name)
+	printUsage()
+	return exitUsage
+}
+
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+func printUsage() {
+	fmt.Fprint(os.Stderr, "Usage: ip_scanner <command> [options]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprint(os.Stderr, "\nRun \"ip_scanner <command> -h\" for the options of a command.\n")
+}
+
+// newFlagSet returns the flag set of a command, with a usage message in the same
+// format for every command.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		for _, c := range commands {
+			if c.name != strings.Fields(name)[0] {
+				continue
+			}
+			synopsis := strings.TrimSpace("ip_scanner " + c.name + " [options] " + c.args)
+			fmt.Fprintf(flags.Output(), "Usage: %s\n\n%s.\n\nOptions:\n", synopsis, c.summary)
+		}
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// configFlags are the options of every command that reads the configuration file.
+// Besides -config and -site, every key of the configuration outside Sites can be
+// overridden with a flag such as --ping.protocol=tcp or --dns.listen=127.0.0.1:5353.
+type configFlags struct {
+	flags *flag.FlagSet
+	path  *string
+	site  *string
+}
+
+func addConfigFlags(flags *flag.FlagSet) configFlags {
+	cf := configFlags{
+		flags: flags,
+		path:  flags.String("config", "./configs/config.toml", "Config file, toml format"),
+		site: flags.String("site", "",
+			"This option should specify the site that exists under Sites configured in config.toml, such as GoogleTranslate, Cloudflare.\nSeveral comma separated sites are worked on in one run"),
+	}
+	addKeyFlags(flags)
+	return cf
+}
+
+// load reads the configuration and validates it, reporting every problem at once.
+func (f configFlags) load() (*common.Config, error) {
+	config, err := LoadConfig(*f.path, *f.site, keyOverrides(f.flags))
+	if err != nil {
+		return nil, err
+	}
+	if err = config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s:\n%w", *f.path, err)
+	}
+	return config, nil
+}
+
+// setKey sets the flag of a configuration key from a shorter option, such as
+// -hosts-file for general.hostsfile, so it overrides the key like the long flag does.
+func setKey(flags *flag.FlagSet, key string, value string) error {
+	if value == "" {
+		return nil
+	}
+	return flags.Set(key, value)
+}
+
+// parseInterspersed parses flags that may come after positional arguments, such as
+// "sample 100 -site Cloudflare", and returns the positional arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// fail prints the error of a command and returns the exit code for it.
+func fail(name string, err error) int {
+	fmt.Fprintf(os.Stderr, "ip_scanner %s: %v\n", name, err)
+	return exitError
+}
+
+// writeMetricsFile writes the metrics of the command to General.MetricsFile, if set.
+func writeMetricsFile(config *common.Config) {
+	if config.General.MetricsFile == "" {
+		return
+	}
+	if err := common.WriteMetricsFile(config.General.MetricsFile); err != nil {
+		fail("metrics", err)
+	}
+}
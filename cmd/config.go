@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"fmt"
+)
+
+func runConfig(args []string) int {
+	flags := newFlagSet("config")
+	cf := addConfigFlags(flags)
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 || positional[0] != "validate" {
+		flags.Usage()
+		return exitUsage
+	}
+	if _, err = cf.load(); err != nil {
+		return fail("config", err)
+	}
+	fmt.Printf("%s is valid\n", *cf.path)
+	return exitOK
+}
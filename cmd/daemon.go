@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"github.com/csyezheng/ip-scanner/common"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func runDaemon(args []string) int {
+	flags := newFlagSet("daemon")
+	cf := addConfigFlags(flags)
+	yes := flags.Bool("yes", false, "Write the healthy IPs to the hosts file, same as --general.applyhosts=yes")
+	hostsFile := addHostsFileFlag(flags)
+	reloadCmd := addReloadCmdFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if err := setKey(flags, "general.hostsfile", *hostsFile); err != nil {
+		return fail("daemon", err)
+	}
+	if err := setKey(flags, "general.reloadcmd", *reloadCmd); err != nil {
+		return fail("daemon", err)
+	}
+	config, err := cf.load()
+	if err != nil {
+		return fail("daemon", err)
+	}
+	if *yes {
+		config.General.ApplyHosts = common.ApplyHostsYes
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if config.Daemon.MetricsListen != "" {
+		// The metrics of the daemon, to alert on its checks and scans. The daemon keeps
+		// running without them.
+		go func() {
+			if err := common.ListenAndServeMetrics(ctx, config.Daemon.MetricsListen); err != nil {
+				slog.Error("Metrics server failed:", "error", err)
+			}
+		}()
+	}
+	if err = common.RunDaemon(ctx, config); err != nil {
+		return fail("daemon", err)
+	}
+	return exitOK
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"github.com/csyezheng/ip-scanner/common"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// resultsRefresh is how often the dns and proxy commands check for the results of a newer scan.
+const resultsRefresh = 10 * time.Second
+
+func runDNS(args []string) int {
+	flags := newFlagSet("dns")
+	cf := addConfigFlags(flags)
+	listen := flags.String("listen", "", "Address to listen on, UDP and TCP, such as 127.0.0.1:5353. Overrides dns.listen")
+	upstream := flags.String("upstream", "", "Resolver for the other domains, such as 8.8.8.8:53. Overrides dns.upstream")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if err := setKey(flags, "dns.listen", *listen); err != nil {
+		return fail("dns", err)
+	}
+	if err := setKey(flags, "dns.upstream", *upstream); err != nil {
+		return fail("dns", err)
+	}
+	config, err := cf.load()
+	if err != nil {
+		return fail("dns", err)
+	}
+	server := &common.DNSServer{
+		Listen:   config.DNS.Listen,
+		Upstream: config.DNS.Upstream,
+		TTL:      uint32(config.DNS.TTL),
+	}
+	if server.Listen == "" {
+		server.Listen = "127.0.0.1:53"
+	}
+	if server.Upstream == "" {
+		server.Upstream = "1.1.1.1:53"
+	}
+	if server.TTL == 0 {
+		server.TTL = 60
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go watchResults(ctx, config, func() {
+		server.SetRecords(dnsEntries(config))
+	})
+	if err = server.ListenAndServe(ctx); err != nil {
+		return fail("dns", err)
+	}
+	return exitOK
+}
+
+// watchResults calls load with the results of the last scan of every site, and again
+// when newer scans are written, until ctx is done.
+func watchResults(ctx context.Context, config *common.Config, load func()) {
+	var loadedAt time.Time
+	ticker := time.NewTicker(resultsRefresh)
+	defer ticker.Stop()
+	for {
+		if modified := resultsModified(config); modified.After(loadedAt) {
+			loadedAt = modified
+			load()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resultsModified returns the time the results of a selected site were last written.
+func resultsModified(config *common.Config) time.Time {
+	var latest time.Time
+	for _, name := range config.SelectedSites() {
+		modified := common.ResultsModTime(common.RetrieveSiteCfg(config.ForSite(name)).Site)
+		if modified.After(latest) {
+			latest = modified
+		}
+	}
+	return latest
+}
+
+func dnsEntries(config *common.Config) []common.HostsEntry {
+	var entries []common.HostsEntry
+	for _, name := range config.SelectedSites() {
+		siteConfig := config.ForSite(name)
+		scanRecords, err := common.LoadResults(common.RetrieveSiteCfg(siteConfig).Site)
+		if err != nil {
+			slog.Warn("No results of the site, its domains are forwarded", "Site", name, "error", err)
+			continue
+		}
+		siteEntries := common.HostsEntries(scanRecords, siteConfig)
+		slog.Info("Serving the best IPs:", "Site", name, "Entries", len(siteEntries))
+		entries = append(entries, siteEntries...)
+	}
+	return entries
+}
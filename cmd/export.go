@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/csyezheng/ip-scanner/common"
+	"os"
+	"strings"
+)
+
+// addReloadCmdFlag registers -reload-cmd, a shorter name for --general.reloadcmd.
+func addReloadCmdFlag(flags *flag.FlagSet) *string {
+	return flags.String("reload-cmd", "", "Shell command to run after exporting, such as \"systemctl reload dnsmasq\"")
+}
+
+func runExport(args []string) int {
+	flags := newFlagSet("export")
+	cf := addConfigFlags(flags)
+	formatFlag := flags.String("format", "",
+		"Comma separated formats: "+strings.Join(common.ExportFormats(), ", ")+". General.Exports by default")
+	output := flags.String("o", "", "Write to this file, - for stdout, instead of next to the IPOutputFile of the site")
+	reloadCmd := addReloadCmdFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if err := setKey(flags, "general.reloadcmd", *reloadCmd); err != nil {
+		return fail("export", err)
+	}
+	config, err := cf.load()
+	if err != nil {
+		return fail("export", err)
+	}
+	formats := config.General.Exports
+	if *formatFlag != "" {
+		formats = strings.Split(*formatFlag, ",")
+	}
+	if len(formats) == 0 {
+		return fail("export", errors.New("no format given with -format or General.Exports"))
+	}
+	if *output != "" && len(formats)*len(config.SelectedSites()) > 1 {
+		return fail("export", errors.New("-o can only be used with one format and one site"))
+	}
+	written := false
+	for _, name := range config.SelectedSites() {
+		siteConfig := config.ForSite(name)
+		site := common.RetrieveSiteCfg(siteConfig)
+		scanRecords, err := common.LoadResults(site.Site)
+		if err != nil {
+			return fail("export", fmt.Errorf("no results of the last scan of %s, run scan first: %w", name, err))
+		}
+		entries := common.HostsEntries(scanRecords, siteConfig)
+		for _, format := range formats {
+			content, err := common.RenderExport(strings.TrimSpace(format), site, scanRecords, entries)
+			if err != nil {
+				return fail("export", err)
+			}
+			dest := *output
+			if dest == "" {
+				dest = common.ExportFile(site.Site, strings.TrimSpace(format))
+			}
+			if dest == "-" {
+				fmt.Print(content)
+				continue
+			}
+			if err = common.WriteFileAtomic(dest, []byte(content), 0644); err != nil {
+				return fail("export", err)
+			}
+			fmt.Fprintf(os.Stderr, "%d entries written to %s\n", len(entries), dest)
+			written = true
+		}
+	}
+	if written {
+		if err = common.RunReloadCmd(config.General.ReloadCmd); err != nil {
+			return fail("export", err)
+		}
+	}
+	return exitOK
+}
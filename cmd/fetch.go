@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"github.com/csyezheng/ip-scanner/sites"
+)
+
+func runFetch(args []string) int {
+	flags := newFlagSet("fetch")
+	cf := addConfigFlags(flags)
+	force := flags.Bool("force", false, "Fetch and rewrite the ranges file even if the published list did not change")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	config, err := cf.load()
+	if err != nil {
+		return fail("fetch", err)
+	}
+	code := exitOK
+	for _, name := range config.SelectedSites() {
+		if err = sites.FetchIPRanges(config.ForSite(name), *force); err != nil {
+			code = fail("fetch "+name, err)
+		}
+	}
+	writeMetricsFile(config)
+	return code
+}
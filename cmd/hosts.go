@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/csyezheng/ip-scanner/common"
+)
+
+// addHostsFileFlag registers -hosts-file, a shorter name for --general.hostsfile.
+func addHostsFileFlag(flags *flag.FlagSet) *string {
+	return flags.String("hosts-file", "", "Hosts file to modify instead of the one of the operating system")
+}
+
+func runHosts(args []string) int {
+	flags := newFlagSet("hosts")
+	cf := addConfigFlags(flags)
+	ip := flags.String("ip", "", "The IP to write, the best IP of the last scan by default")
+	hostsFileFlag := addHostsFileFlag(flags)
+	backup := flags.String("backup", "", "The backup to restore, the latest one by default")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	if err = setKey(flags, "general.hostsfile", *hostsFileFlag); err != nil {
+		return fail("hosts", err)
+	}
+	config, err := cf.load()
+	if err != nil {
+		return fail("hosts", err)
+	}
+	hostsFile, err := common.HostsPath(config)
+	if err != nil {
+		return fail("hosts", err)
+	}
+	switch positional[0] {
+	case "apply":
+		if *ip != "" && len(config.SelectedSites()) > 1 {
+			return fail("hosts", errors.New("-ip can only be used with one site"))
+		}
+		for _, name := range config.SelectedSites() {
+			if err = applyHosts(hostsFile, config.ForSite(name), *ip); err != nil {
+				return fail("hosts "+name, err)
+			}
+		}
+	case "remove":
+		for _, name := range config.SelectedSites() {
+			if err = common.RemoveFromHosts(hostsFile, name); err != nil {
+				return fail("hosts "+name, err)
+			}
+		}
+	case "restore":
+		if _, err = common.RestoreHosts(hostsFile, *backup); err != nil {
+			return fail("hosts", err)
+		}
+	case "backups":
+		backups, err := common.HostsBackups(hostsFile)
+		if err != nil {
+			return fail("hosts", err)
+		}
+		for _, backup := range backups {
+			fmt.Println(backup)
+		}
+	default:
+		flags.Usage()
+		return exitUsage
+	}
+	return exitOK
+}
+
+// applyHosts points the domains of the site to ip, or to the best IPs of its last scan.
+func applyHosts(hostsFile string, config *common.Config, ip string) error {
+	site := common.RetrieveSiteCfg(config)
+	if ip != "" {
+		return common.WriteToHosts(hostsFile, site.Name, common.HostsEntriesFor(ip, site.Domains))
+	}
+	scanRecords, err := common.LoadResults(site.Site)
+	if err != nil {
+		return fmt.Errorf("no results of the last scan, run scan first: %w", err)
+	}
+	if len(scanRecords) == 0 {
+		return errors.New("the last scan found no IP")
+	}
+	return common.WriteToHosts(hostsFile, site.Name, common.HostsEntries(scanRecords, config))
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"github.com/csyezheng/ip-scanner/common"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func runProxy(args []string) int {
+	flags := newFlagSet("proxy")
+	cf := addConfigFlags(flags)
+	listen := flags.String("listen", "", "Address to listen on, such as 127.0.0.1:443. Overrides proxy.listen")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if err := setKey(flags, "proxy.listen", *listen); err != nil {
+		return fail("proxy", err)
+	}
+	config, err := cf.load()
+	if err != nil {
+		return fail("proxy", err)
+	}
+	proxy := &common.SNIProxy{Listen: config.Proxy.Listen}
+	if proxy.Listen == "" {
+		proxy.Listen = "127.0.0.1:8443"
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go watchResults(ctx, config, func() {
+		proxy.SetRoutes(proxyRoutes(config))
+	})
+	if err = proxy.ListenAndServe(ctx); err != nil {
+		return fail("proxy", err)
+	}
+	return exitOK
+}
+
+// proxyRoutes routes the Domains of every selected site, and the host of its HttpsURL,
+// to the IPs of its last scan.
+func proxyRoutes(config *common.Config) []common.ProxyRoute {
+	var routes []common.ProxyRoute
+	for _, name := range config.SelectedSites() {
+		site := common.RetrieveSiteCfg(config.ForSite(name))
+		scanRecords, err := common.LoadResults(site.Site)
+		if err != nil {
+			slog.Warn("No results of the site, its connections are closed", "Site", name, "error", err)
+			continue
+		}
+		route := common.ProxyRoute{Site: name, Domains: site.Domains, Port: site.HTTP.Port}
+		if u, err := url.Parse(site.HttpsURL); err == nil && u.Hostname() != "" {
+			route.Domains = append(append([]string(nil), site.Domains...), u.Hostname())
+		}
+		seen := make(map[string]bool)
+		for _, record := range scanRecords {
+			if ip := record.Addr(); !seen[ip] {
+				seen[ip] = true
+				route.IPs = append(route.IPs, ip)
+			}
+		}
+		slog.Info("Proxying to the best IPs:", "Site", name, "IPs", len(route.IPs))
+		routes = append(routes, route)
+	}
+	return routes
+}
@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/csyezheng/ip-scanner/common"
+	"io"
+	"math/big"
+	"math/rand"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSplitPrefixes keeps split from producing absurdly large files, e.g. an IPv6 /32 into /64s.
+const maxSplitPrefixes = 1 << 20
+
+const rangesOperations = `
+Operations:
+  count                 count the prefixes and addresses of each file
+  merge, aggregate      merge all files into the smallest list of prefixes
+  subtract A B...       the addresses of A that are not in any of B
+  split -prefix 24      split the prefixes into prefixes of the given length
+  sample N              pick N random IPs
+  from-results          the prefixes covering the IPs of an IPOutputFile
+
+Files use the ip ranges file format, see README.md. The result is printed, written
+to -o, or to the CustomIPRangesFile of -site with -write-custom.
+`
+
+// rangesOptions are the options shared by every ranges operation.
+type rangesOptions struct {
+	flags       *flag.FlagSet
+	cf          configFlags
+	output      *string
+	writeCustom *bool
+	prefixLen   *int
+	args        []string
+}
+
+func newRangesFlags() rangesOptions {
+	flags := newFlagSet("ranges")
+	usage := flags.Usage
+	flags.Usage = func() {
+		usage()
+		fmt.Fprint(flags.Output(), rangesOperations)
+	}
+	return rangesOptions{
+		flags:       flags,
+		cf:          addConfigFlags(flags),
+		output:      flags.String("o", "", "Write the result to this file instead of printing it"),
+		writeCustom: flags.Bool("write-custom", false, "Write the result to the CustomIPRangesFile of the site"),
+		prefixLen:   flags.Int("prefix", 24, "Prefix length for split and from-results"),
+	}
+}
+
+// runRanges runs the CIDR toolkit with the command line arguments after "ranges".
+func runRanges(args []string) int {
+	opts := newRangesFlags()
+	var err error
+	if opts.args, err = parseInterspersed(opts.flags, args); err != nil {
+		return exitUsage
+	}
+	if len(opts.args) == 0 {
+		opts.flags.Usage()
+		return exitUsage
+	}
+	operation := opts.args[0]
+	opts.args = opts.args[1:]
+	lines, err := rangesOperation(operation, opts)
+	if err != nil {
+		return fail("ranges "+operation, err)
+	}
+	if err = opts.write(operation, lines); err != nil {
+		return fail("ranges "+operation, err)
+	}
+	return exitOK
+}
+
+func rangesOperation(operation string, opts rangesOptions) ([]string, error) {
+	args := opts.args
+	switch operation {
+	case "count":
+		return countRanges(args)
+	case "merge", "aggregate":
+		prefixes, err := readRangeFiles(args)
+		if err != nil {
+			return nil, err
+		}
+		return common.PrefixStrings(common.MergePrefixes(prefixes)), nil
+	case "subtract":
+		if len(args) < 2 {
+			return nil, errors.New("subtract needs a file to subtract from and at least one file to subtract")
+		}
+		prefixes, err := readRangeFiles(args[:1])
+		if err != nil {
+			return nil, err
+		}
+		exclude, err := readRangeFiles(args[1:])
+		if err != nil {
+			return nil, err
+		}
+		return common.PrefixStrings(common.SubtractPrefixes(prefixes, exclude)), nil
+	case "split":
+		prefixes, err := readRangeFiles(args)
+		if err != nil {
+			return nil, err
+		}
+		return splitPrefixes(common.MergePrefixes(prefixes), *opts.prefixLen, maxSplitPrefixes)
+	case "sample":
+		if len(args) < 1 {
+			return nil, errors.New("sample needs the number of IPs")
+		}
+		n, err := parsePositive(args[0])
+		if err != nil {
+			return nil, err
+		}
+		files, err := opts.defaultFiles(args[1:], func(site common.Site) string { return site.IPRangesFile })
+		if err != nil {
+			return nil, err
+		}
+		prefixes, err := readRangeFiles(files)
+		if err != nil {
+			return nil, err
+		}
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		var lines []string
+		for _, addr := range common.SampleAddrs(prefixes, n, rng) {
+			lines = append(lines, addr.String())
+		}
+		return lines, nil
+	case "from-results":
+		files, err := opts.defaultFiles(args, func(site common.Site) string { return site.IPOutputFile })
+		if err != nil {
+			return nil, err
+		}
+		var prefixes []netip.Prefix
+		for _, file := range files {
+			addrs, err := readResultAddrs(file)
+			if err != nil {
+				return nil, err
+			}
+			for _, addr := range addrs {
+				prefixes = append(prefixes, common.CoveringPrefix(addr, *opts.prefixLen))
+			}
+		}
+		return common.PrefixStrings(common.MergePrefixes(prefixes)), nil
+	}
+	return nil, fmt.Errorf("unknown operation, run ip_scanner ranges -h for the available operations")
+}
+
+// splitPrefixes splits every prefix into prefixes of length bits. The number of prefixes
+// is worked out before splitting, so a split into more than limit fails without building them.
+func splitPrefixes(prefixes []netip.Prefix, bits int, limit int) ([]string, error) {
+	var lines []string
+	for _, p := range prefixes {
+		if bits < p.Bits() || bits > p.Addr().BitLen() {
+			return nil, fmt.Errorf("-prefix %d must be between %d and %d to split %s", bits, p.Bits(), p.Addr().BitLen(), p)
+		}
+		count := new(big.Int).Lsh(big.NewInt(1), uint(bits-p.Bits()))
+		if count.Cmp(big.NewInt(int64(limit-len(lines)))) > 0 {
+			return nil, fmt.Errorf("more than %d prefixes, use a shorter -prefix", limit)
+		}
+		for _, sub := range common.SplitPrefix(p, bits) {
+			lines = append(lines, sub.String())
+		}
+	}
+	return lines, nil
+}
+
+func countRanges(files []string) ([]string, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no files given")
+	}
+	lines := []string{"FILE\tPREFIXES\tADDRESSES"}
+	var all []netip.Prefix
+	for _, file := range files {
+		prefixes, err := readRangeFiles([]string{file})
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, prefixes...)
+		lines = append(lines, fmt.Sprintf("%s\t%d\t%s", file, len(prefixes), common.CountAddrs(prefixes)))
+	}
+	if len(files) > 1 {
+		merged := common.MergePrefixes(all)
+		lines = append(lines, fmt.Sprintf("%s\t%d\t%s", "total", len(merged), common.CountAddrs(merged)))
+	}
+	return lines, nil
+}
+
+// readRangeFiles reads ranges files into their normalized prefixes, with exclusions applied.
+func readRangeFiles(files []string) ([]netip.Prefix, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no files given")
+	}
+	var entries []common.RangeEntry
+	for _, file := range files {
+		more, err := common.ParseRangesFile(file)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, more...)
+	}
+	var prefixes []netip.Prefix
+	for _, group := range common.NormalizeRanges(entries, nil, true) {
+		prefixes = append(prefixes, group.Prefixes...)
+	}
+	return prefixes, nil
+}
+
+// readResultAddrs reads the IPs of an IPOutputFile, whose lines are ip or ip:port.
+func readResultAddrs(file string) ([]netip.Addr, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var addrs []netip.Addr
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		record := common.ScanRecord{IP: strings.Fields(line)[0]}
+		addr, err := netip.ParseAddr(record.Addr())
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid ip %q", file, line)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, scanner.Err()
+}
+
+func parsePositive(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
+
+func (opts rangesOptions) loadSite() (common.Site, error) {
+	config, err := opts.cf.load()
+	if err != nil {
+		return common.Site{}, err
+	}
+	if len(config.SelectedSites()) > 1 {
+		return common.Site{}, errors.New("ranges works on one site at a time")
+	}
+	return common.RetrieveSiteCfg(config).Site, nil
+}
+
+// defaultFiles returns files, or the file of the configured site when none are given.
+func (opts rangesOptions) defaultFiles(files []string, fileOf func(common.Site) string) ([]string, error) {
+	if len(files) > 0 {
+		return files, nil
+	}
+	site, err := opts.loadSite()
+	if err != nil {
+		return nil, err
+	}
+	return []string{fileOf(site)}, nil
+}
+
+func (opts rangesOptions) write(operation string, lines []string) error {
+	dest := *opts.output
+	if *opts.writeCustom {
+		site, err := opts.loadSite()
+		if err != nil {
+			return err
+		}
+		dest = site.CustomIPRangesFile
+	}
+	if dest == "" {
+		return writeLines(os.Stdout, lines)
+	}
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "# Generated by ip_scanner ranges %s at %s\n", operation, time.Now().Format(time.RFC3339))
+	if err := writeLines(&builder, lines); err != nil {
+		return err
+	}
+	if err := common.WriteFileAtomic(dest, []byte(builder.String()), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d lines written to %s\n", len(lines), dest)
+	return nil
+}
+
+func writeLines(w io.Writer, lines []string) error {
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitPrefixes(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		bits    int
+		limit   int
+		want    []string
+		wantErr string
+	}{
+		{"ipv4", "192.0.2.0/23", 24, 10, []string{"192.0.2.0/24", "192.0.3.0/24"}, ""},
+		{"same length", "192.0.2.0/24", 24, 10, []string{"192.0.2.0/24"}, ""},
+		{"at the limit", "192.0.2.0/22", 24, 4, []string{"192.0.0.0/24", "192.0.1.0/24", "192.0.2.0/24", "192.0.3.0/24"}, ""},
+		{"over the limit", "192.0.2.0/22", 24, 3, nil, "more than 3 prefixes"},
+		{"ipv6 over the limit", "2001:db8::/40", 64, maxSplitPrefixes, nil, "more than 1048576 prefixes"},
+		{"ipv6 whole space", "::/0", 128, maxSplitPrefixes, nil, "more than 1048576 prefixes"},
+		{"shorter than the prefix", "192.0.2.0/24", 16, 10, nil, "-prefix 16 must be between 24 and 32"},
+		{"longer than the address", "192.0.2.0/24", 33, 10, nil, "-prefix 33 must be between 24 and 32"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := netip.MustParsePrefix(tt.prefix).Masked()
+			got, err := splitPrefixes([]netip.Prefix{p}, tt.bits, tt.limit)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("splitPrefixes() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitPrefixes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRangesSplitLimit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ranges.txt")
+	if err := os.WriteFile(file, []byte("2001:db8::/40\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prefixLen := 64
+	_, err := rangesOperation("split", rangesOptions{prefixLen: &prefixLen, args: []string{file}})
+	if err == nil || !strings.Contains(err.Error(), "more than") {
+		t.Errorf("split -prefix 64 of 2001:db8::/40 error = %v, want too many prefixes", err)
+	}
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/csyezheng/ip-scanner/common"
+)
+
+func runResults(args []string) int {
+	flags := newFlagSet("results")
+	cf := addConfigFlags(flags)
+	limit := flags.Int("n", 10, "Number of IPs to show, all if it is less than or equal to 0")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	config, err := cf.load()
+	if err != nil {
+		return fail("results", err)
+	}
+	names := config.SelectedSites()
+	for i, name := range names {
+		scanRecords, err := common.LoadResults(common.RetrieveSiteCfg(config.ForSite(name)).Site)
+		if err != nil {
+			return fail("results", err)
+		}
+		if *limit > 0 && len(scanRecords) > *limit {
+			scanRecords = scanRecords[:*limit]
+		}
+		if len(names) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s:\n", name)
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", "IP", "Protocol", "PingRTT", "HttpRTT")
+		for _, record := range scanRecords {
+			fmt.Printf("%s\t%s\t%.f\t%.f\n", record.IP, record.Protocol, record.PingRTT, record.HttpRTT)
+		}
+	}
+	return exitOK
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/csyezheng/ip-scanner/common"
+)
+
+func runScan(args []string) int {
+	flags := newFlagSet("scan")
+	cf := addConfigFlags(flags)
+	applyHosts := flags.String("apply-hosts", "",
+		"Write the fastest IP to the hosts file: yes, no or ask. ask declines when stdin is not a terminal")
+	yes := flags.Bool("yes", false, "Same as -apply-hosts=yes")
+	no := flags.Bool("no", false, "Same as -apply-hosts=no")
+	hostsFile := addHostsFileFlag(flags)
+	reloadCmd := addReloadCmdFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if err := setKey(flags, "general.hostsfile", *hostsFile); err != nil {
+		return fail("scan", err)
+	}
+	if err := setKey(flags, "general.reloadcmd", *reloadCmd); err != nil {
+		return fail("scan", err)
+	}
+	config, err := cf.load()
+	if err != nil {
+		return fail("scan", err)
+	}
+	switch {
+	case *yes && *no:
+		fmt.Fprintln(flags.Output(), "-yes and -no cannot be used together")
+		return exitUsage
+	case *yes:
+		config.General.ApplyHosts = common.ApplyHostsYes
+	case *no:
+		config.General.ApplyHosts = common.ApplyHostsNo
+	case *applyHosts == common.ApplyHostsYes || *applyHosts == common.ApplyHostsNo || *applyHosts == common.ApplyHostsAsk:
+		config.General.ApplyHosts = *applyHosts
+	case *applyHosts != "":
+		fmt.Fprintf(flags.Output(), "invalid value %q for -apply-hosts: must be yes, no or ask\n", *applyHosts)
+		return exitUsage
+	}
+	code := scanExitCode(common.Run(config))
+	writeMetricsFile(config)
+	return code
+}
+
+// scanExitCode turns the outcome of a scan into the exit code, so scripts can tell a
+// failed hosts update (exitHostsFailed) from sites without any IP found (exitNoneFound).
+func scanExitCode(err error) int {
+	switch {
+	case errors.Is(err, common.ErrHostsWrite):
+		return exitHostsFailed
+	case errors.Is(err, common.ErrNoIPFound):
+		return exitNoneFound
+	case err != nil:
+		return exitError
+	}
+	return exitOK
+}
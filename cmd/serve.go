@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"context"
+	"github.com/csyezheng/ip-scanner/common"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func runServe(args []string) int {
+	flags := newFlagSet("serve")
+	cf := addConfigFlags(flags)
+	listen := flags.String("listen", "", "Address to listen on, such as 127.0.0.1:8080. Overrides api.listen")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if err := setKey(flags, "api.listen", *listen); err != nil {
+		return fail("serve", err)
+	}
+	config, err := cf.load()
+	if err != nil {
+		return fail("serve", err)
+	}
+	server := &common.APIServer{Listen: config.API.Listen, Config: config}
+	if server.Listen == "" {
+		server.Listen = "127.0.0.1:8080"
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err = server.ListenAndServe(ctx); err != nil {
+		return fail("serve", err)
+	}
+	return exitOK
+}
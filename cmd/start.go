@@ -1,40 +1,223 @@
 package cmd
 
 import (
+	"flag"
 	"fmt"
 	"github.com/csyezheng/ip-scanner/common"
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
-	"log"
 	"log/slog"
+	"math"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func Start(configFilePath string, siteFlag string) {
+// envPrefix starts the environment variables that override configuration keys,
+// e.g. IPSCANNER_PING_PROTOCOL for ping.protocol.
+const envPrefix = "IPSCANNER"
+
+// Configuration keys are resolved in this order, the first one set wins:
+//
+//  1. a command line flag, e.g. --ping.protocol=tcp (and -site for general.site)
+//  2. an environment variable, e.g. IPSCANNER_PING_PROTOCOL=tcp
+//  3. the configuration file
+//  4. the zero value
+//
+// Every key of every section, General, Ping, HTTP, Daemon, DNS, Proxy and API, can be
+// overridden. Sites can only be configured in the file. Timeouts and intervals are
+// milliseconds, or a duration such as 2s.
+
+// configKey is a key of the configuration that can be overridden, such as ping.protocol.
+type configKey struct {
+	name string
+	typ  reflect.Type
+}
+
+// configKeys lists the overridable keys of common.Config, found by reflection so new
+// fields are picked up without further work.
+func configKeys() []configKey {
+	var keys []configKey
+	configType := reflect.TypeOf(common.Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		section := configType.Field(i)
+		if section.Type.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.Type.NumField(); j++ {
+			field := section.Type.Field(j)
+			if !field.IsExported() {
+				continue
+			}
+			name := strings.ToLower(section.Name + "." + field.Name)
+			keys = append(keys, configKey{name: name, typ: field.Type})
+		}
+	}
+	return keys
+}
+
+func envName(key string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// addKeyFlags registers a flag for every overridable configuration key.
+func addKeyFlags(flags *flag.FlagSet) {
+	for _, key := range configKeys() {
+		usage := fmt.Sprintf("Override %s, also set by %s", key.name, envName(key.name))
+		switch {
+		case key.typ == reflect.TypeOf(time.Duration(0)):
+			flags.String(key.name, "", usage+" (milliseconds or a duration such as 2s)")
+		case key.typ.Kind() == reflect.Bool:
+			flags.Bool(key.name, false, usage)
+		case key.typ.Kind() == reflect.Slice:
+			flags.String(key.name, "", usage+" (comma separated)")
+		case key.typ.Kind() == reflect.String:
+			flags.String(key.name, "", usage)
+		default:
+			flags.Int(key.name, 0, usage)
+		}
+	}
+}
+
+// keyOverrides returns the configuration keys set on the command line.
+func keyOverrides(flags *flag.FlagSet) map[string]any {
+	overrides := make(map[string]any)
+	flags.Visit(func(f *flag.Flag) {
+		if strings.Contains(f.Name, ".") {
+			overrides[f.Name] = f.Value.(flag.Getter).Get()
+		}
+	})
+	return overrides
+}
+
+// millisecondsHook decodes durations given as strings, from the environment or flags,
+// into milliseconds like the plain numbers of the configuration file.
+func millisecondsHook(from reflect.Type, to reflect.Type, data any) (any, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(time.Duration(0)) {
+		return data, nil
+	}
+	s := strings.TrimSpace(data.(string))
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ms, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, err
+	}
+	return int64(d / time.Millisecond), nil
+}
+
+// intRangeHook refuses numbers that do not fit the sized integer they are decoded into,
+// such as a port of 70000, instead of letting them wrap around.
+func intRangeHook(from reflect.Type, to reflect.Type, data any) (any, error) {
+	switch to.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+	default:
+		return data, nil
+	}
+	value := reflect.ValueOf(data)
+	if from.Kind() == reflect.String {
+		n, err := strconv.ParseInt(strings.TrimSpace(value.String()), 0, 64)
+		if err != nil {
+			// Not a number, the decoder reports it.
+			return data, nil
+		}
+		value = reflect.ValueOf(n)
+	}
+	field := reflect.New(to).Elem()
+	overflow := false
+	switch {
+	case value.CanInt() && field.CanInt():
+		overflow = field.OverflowInt(value.Int())
+	case value.CanInt():
+		overflow = value.Int() < 0 || field.OverflowUint(uint64(value.Int()))
+	case value.CanUint() && field.CanUint():
+		overflow = field.OverflowUint(value.Uint())
+	case value.CanUint():
+		overflow = value.Uint() > math.MaxInt32 || field.OverflowInt(int64(value.Uint()))
+	}
+	if overflow {
+		return nil, fmt.Errorf("%v is out of range for %s", data, to)
+	}
+	return data, nil
+}
+
+// LoadConfig reads the toml configuration file, applies the environment variables and
+// the overrides from the command line, selects siteFlag if it is not empty and sets up logging.
+func LoadConfig(configFilePath string, siteFlag string, overrides map[string]any) (*common.Config, error) {
 	viper.SetConfigType("toml")
 	viper.SetConfigFile(configFilePath)
 	err := viper.ReadInConfig()
 	if err != nil {
-		panic(fmt.Errorf("fatal error config file: %w", err))
+		return nil, fmt.Errorf("fatal error config file: %w", err)
+	}
+	for _, key := range configKeys() {
+		if err = viper.BindEnv(key.name, envName(key.name)); err != nil {
+			return nil, err
+		}
+	}
+	for key, value := range overrides {
+		viper.Set(key, value)
 	}
 	var config common.Config
-	err = viper.Unmarshal(&config)
+	var metadata mapstructure.Metadata
+	err = viper.Unmarshal(&config, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		millisecondsHook,
+		intRangeHook,
+		mapstructure.StringToSliceHookFunc(","),
+	)), func(decoderConfig *mapstructure.DecoderConfig) {
+		decoderConfig.Metadata = &metadata
+	})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	for _, key := range metadata.Unused {
+		config.UnknownKeys = append(config.UnknownKeys, strings.ToLower(key))
+	}
+	sort.Strings(config.UnknownKeys)
 	if siteFlag != "" {
 		config.General.Site = siteFlag
+		config.General.Sites = nil
 	}
 	config.Ping.Timeout = config.Ping.Timeout * time.Millisecond
 	config.HTTP.Timeout = config.HTTP.Timeout * time.Millisecond
+	config.Daemon.CheckInterval = config.Daemon.CheckInterval * time.Millisecond
+	config.Daemon.RescanInterval = config.Daemon.RescanInterval * time.Millisecond
+	for _, site := range config.Sites {
+		if site.Ping.Timeout != nil {
+			*site.Ping.Timeout = *site.Ping.Timeout * time.Millisecond
+		}
+		if site.HTTP.Timeout != nil {
+			*site.HTTP.Timeout = *site.HTTP.Timeout * time.Millisecond
+		}
+	}
 	if config.General.Debug {
 		handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})
 		logger := slog.New(handler)
 		slog.SetDefault(logger)
+		logConfigSources(overrides)
 	}
-	if !common.AssertSiteName(&config) {
-		log.Printf("eror occur, site %s does not configured in the configuration file", config.General.Site)
-		os.Exit(1)
+	return &config, nil
+}
+
+// logConfigSources logs where the value of every overridable key comes from.
+func logConfigSources(overrides map[string]any) {
+	keys := configKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].name < keys[j].name
+	})
+	for _, key := range keys {
+		source := "default"
+		if _, ok := overrides[key.name]; ok {
+			source = "flag"
+		} else if _, ok := os.LookupEnv(envName(key.name)); ok {
+			source = "env " + envName(key.name)
+		} else if viper.InConfig(key.name) {
+			source = "config file"
+		}
+		slog.Debug("config:", "key", key.name, "value", viper.Get(key.name), "source", source)
 	}
-	common.Run(&config)
 }
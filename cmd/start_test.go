@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigIntRange(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.toml")
+	tests := []struct {
+		name      string
+		config    string
+		env       string
+		overrides map[string]any
+		wantErr   string
+	}{
+		{"valid", "[Ping]\nPort = 443\n", "", nil, ""},
+		{"file", "[Ping]\nPort = 70000\n", "", nil, "'Ping.Port': 70000 is out of range for uint16"},
+		{"site", "[[Sites]]\nName = \"A\"\n[Sites.HTTP]\nPort = 65536\n", "", nil, "65536 is out of range for uint16"},
+		{"flag", "", "", map[string]any{"ping.port": 70000}, "'Ping.Port': 70000 is out of range for uint16"},
+		{"negative flag", "", "", map[string]any{"http.port": -1}, "'HTTP.Port': -1 is out of range for uint16"},
+		{"env", "", "70000", nil, "'Ping.Port': 70000 is out of range for uint16"},
+		{"daemon key", "", "", map[string]any{"daemon.failedchecks": 5, "dns.listen": "127.0.0.1:5353"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			if err := os.WriteFile(file, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.env != "" {
+				t.Setenv(envName("ping.port"), tt.env)
+			}
+			config, err := LoadConfig(file, "", tt.overrides)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.overrides["daemon.failedchecks"] != nil && (config.Daemon.FailedChecks != 5 || config.DNS.Listen != "127.0.0.1:5353") {
+				t.Errorf("Daemon and DNS keys were not overridden: %+v %+v", config.Daemon, config.DNS)
+			}
+		})
+	}
+}
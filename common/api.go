@@ -0,0 +1,249 @@
+package common
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// APIServer serves the progress of the scans, the results of the sites and a dashboard
+// over HTTP, and starts scans on request:
+//
+//	GET  /status           progress of the current or last scan, see Status
+//	GET  /results?site=    records of the last scan of a site
+//	GET  /best/<site>      fastest record of the last scan of a site, ?format=ip for the bare IP
+//	POST /scan?site=       scan the sites, comma separated, the selected ones by default
+//	GET  /metrics          telemetry of the scans, probes and fetches for Prometheus
+type APIServer struct {
+	Listen string
+	Config *Config
+
+	mutex    sync.Mutex
+	scanning bool
+}
+
+// ListenAndServe serves on Listen until ctx is done, which also stops a running scan.
+func (server *APIServer) ListenAndServe(ctx context.Context) error {
+	dashboard, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", server.handleStatus)
+	mux.HandleFunc("/results", server.handleResults)
+	mux.HandleFunc("/best/", server.handleBest)
+	mux.HandleFunc("/metrics", MetricsHandler)
+	mux.HandleFunc("/scan", func(w http.ResponseWriter, r *http.Request) {
+		server.handleScan(ctx, w, r)
+	})
+	mux.Handle("/", http.FileServer(http.FS(dashboard)))
+	slog.Info("API server listening:", "Address", server.Listen)
+	return serveHTTP(ctx, server.Listen, mux)
+}
+
+// ListenAndServeMetrics serves only /metrics and /status on listen until ctx is done, so a
+// long running command can be monitored without starting scans.
+func ListenAndServeMetrics(ctx context.Context, listen string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", MetricsHandler)
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, ScanStatus())
+		}
+	})
+	slog.Info("Metrics server listening:", "Address", listen)
+	return serveHTTP(ctx, listen, mux)
+}
+
+func serveHTTP(ctx context.Context, listen string, handler http.Handler) error {
+	httpServer := &http.Server{
+		Addr:              listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (server *APIServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, ScanStatus())
+}
+
+func (server *APIServer) handleResults(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	name := r.URL.Query().Get("site")
+	if name == "" {
+		names := server.Config.SelectedSites()
+		if len(names) != 1 {
+			writeError(w, http.StatusBadRequest, errors.New("the site parameter is required with several sites"))
+			return
+		}
+		name = names[0]
+	}
+	scanRecords, ok := server.loadResults(w, name)
+	if ok {
+		writeJSON(w, http.StatusOK, scanRecords)
+	}
+}
+
+func (server *APIServer) handleBest(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	scanRecords, ok := server.loadResults(w, strings.TrimPrefix(r.URL.Path, "/best/"))
+	if !ok {
+		return
+	}
+	if len(scanRecords) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("the last scan found no IP"))
+		return
+	}
+	if r.URL.Query().Get("format") == "ip" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, scanRecords[0].Addr())
+		return
+	}
+	writeJSON(w, http.StatusOK, scanRecords[0])
+}
+
+// loadResults returns the records of the last scan of the site, or writes the error.
+func (server *APIServer) loadResults(w http.ResponseWriter, name string) (ScanRecordArray, bool) {
+	site, ok := server.Config.SiteByName(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("site %q is not configured", name))
+		return nil, false
+	}
+	scanRecords, err := LoadResults(site.Site)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no results of the last scan of %s: %w", name, err))
+		return nil, false
+	}
+	if scanRecords == nil {
+		scanRecords = ScanRecordArray{}
+	}
+	return scanRecords, true
+}
+
+// handleScan starts a scan in the background, one at a time. Like the daemon, it writes the
+// hosts file only with ApplyHosts = "yes", and the exports. Requests of web pages from
+// other origins are refused, since a scan may rewrite the hosts file and run ReloadCmd.
+func (server *APIServer) handleScan(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if crossOrigin(r) {
+		writeError(w, http.StatusForbidden, errors.New("scans cannot be started from another origin"))
+		return
+	}
+	names := server.Config.SelectedSites()
+	if sites := r.URL.Query().Get("site"); sites != "" {
+		names = strings.Split(sites, ",")
+	}
+	for _, name := range names {
+		if _, ok := server.Config.SiteByName(name); !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("site %q is not configured", name))
+			return
+		}
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.scanning {
+		writeError(w, http.StatusConflict, errors.New("a scan is already running"))
+		return
+	}
+	server.scanning = true
+	go func() {
+		defer func() {
+			server.mutex.Lock()
+			server.scanning = false
+			server.mutex.Unlock()
+		}()
+		scans, err := scanSites(ctx, server.Config, names)
+		if err != nil {
+			slog.Error("Scan failed:", "error", err)
+			return
+		}
+		exported := false
+		for _, scan := range scans {
+			scan.save()
+			if scanRecords := scan.result.scanRecords; len(scanRecords) > 0 {
+				if applyResults(scanRecords, HostsEntries(scanRecords, scan.config), scan.config) {
+					exported = true
+				}
+			}
+		}
+		if exported {
+			if err := RunReloadCmd(server.Config.General.ReloadCmd); err != nil {
+				slog.Error("Reload failed:", "error", err)
+			}
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, map[string][]string{"sites": names})
+}
+
+// crossOrigin reports whether r was sent by a web page of another origin. Browsers set
+// Sec-Fetch-Site and Origin even on the simple requests that CORS lets through, while
+// tools such as curl set neither.
+func crossOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method || method == http.MethodGet && r.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		slog.Debug("api write failed", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
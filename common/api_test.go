@@ -0,0 +1,46 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleScanCrossOrigin(t *testing.T) {
+	config := new(Config)
+	config.General.Site = "Test"
+	config.Sites = []Site{{Name: "Test"}}
+	// A scan is running, so the requests that are let through get a conflict instead of
+	// starting another one.
+	server := &APIServer{Config: config, scanning: true}
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"curl", nil, http.StatusConflict},
+		{"dashboard", map[string]string{"Origin": "http://127.0.0.1:8080", "Sec-Fetch-Site": "same-origin"}, http.StatusConflict},
+		{"old browser on the dashboard", map[string]string{"Origin": "http://127.0.0.1:8080"}, http.StatusConflict},
+		{"address bar", map[string]string{"Sec-Fetch-Site": "none"}, http.StatusConflict},
+		{"other site", map[string]string{"Origin": "https://evil.example", "Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"other port", map[string]string{"Origin": "http://127.0.0.1:3000", "Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{"old browser on another site", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"sandboxed page", map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"fetch metadata only", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "http://127.0.0.1:8080/scan", nil)
+			r.Header.Set("Content-Type", "text/plain")
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			server.handleScan(context.Background(), w, r)
+			if w.Code != tt.want {
+				t.Errorf("POST /scan = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
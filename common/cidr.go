@@ -0,0 +1,281 @@
+package common
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// addrRange is an inclusive range of addresses of the same family.
+type addrRange struct {
+	from netip.Addr
+	to   netip.Addr
+}
+
+// ParseRange parses a prefix (1.2.3.0/24), a single address (1.2.3.4) or an
+// inclusive address range (1.2.3.10-1.2.3.20) into the prefixes covering it.
+func ParseRange(s string) ([]netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if from, to, ok := strings.Cut(s, "-"); ok {
+		start, err := netip.ParseAddr(strings.TrimSpace(from))
+		if err != nil {
+			return nil, err
+		}
+		end, err := netip.ParseAddr(strings.TrimSpace(to))
+		if err != nil {
+			return nil, err
+		}
+		if start.Is4() != end.Is4() || end.Less(start) {
+			return nil, fmt.Errorf("invalid address range %q", s)
+		}
+		return RangeToPrefixes(start, end), nil
+	}
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		return []netip.Prefix{p.Masked()}, nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return nil, err
+	}
+	return []netip.Prefix{netip.PrefixFrom(addr, addr.BitLen())}, nil
+}
+
+// RangeToPrefixes returns the smallest list of prefixes covering exactly from..to.
+func RangeToPrefixes(from, to netip.Addr) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for from.IsValid() && !to.Less(from) {
+		bits := from.BitLen()
+		for bits > 0 {
+			candidate := netip.PrefixFrom(from, bits-1)
+			if candidate.Masked().Addr() != from || to.Less(lastAddr(candidate)) {
+				break
+			}
+			bits--
+		}
+		p := netip.PrefixFrom(from, bits)
+		prefixes = append(prefixes, p)
+		from = lastAddr(p).Next()
+	}
+	return prefixes
+}
+
+// lastAddr returns the highest address of p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	p = p.Masked()
+	if p.Addr().Is4() {
+		b := p.Addr().As4()
+		setHostBits(b[:], p.Bits())
+		return netip.AddrFrom4(b)
+	}
+	b := p.Addr().As16()
+	setHostBits(b[:], p.Bits())
+	return netip.AddrFrom16(b)
+}
+
+func setHostBits(b []byte, bits int) {
+	for i := range b {
+		switch {
+		case bits >= (i+1)*8:
+		case bits <= i*8:
+			b[i] = 0xff
+		default:
+			b[i] |= 0xff >> (bits - i*8)
+		}
+	}
+}
+
+func toRanges(prefixes []netip.Prefix) []addrRange {
+	ranges := make([]addrRange, 0, len(prefixes))
+	for _, p := range prefixes {
+		p = p.Masked()
+		ranges = append(ranges, addrRange{from: p.Addr(), to: lastAddr(p)})
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].from.Less(ranges[j].from)
+	})
+	return ranges
+}
+
+// mergeRanges merges overlapping and adjacent ranges. The input must be sorted.
+func mergeRanges(ranges []addrRange) []addrRange {
+	var merged []addrRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			overlaps := !last.to.Less(r.from)
+			adjacent := last.to.Next() == r.from
+			if last.from.Is4() == r.from.Is4() && (overlaps || adjacent) {
+				if last.to.Less(r.to) {
+					last.to = r.to
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func fromRanges(ranges []addrRange) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, r := range ranges {
+		prefixes = append(prefixes, RangeToPrefixes(r.from, r.to)...)
+	}
+	return prefixes
+}
+
+// MergePrefixes sorts, dedupes and aggregates prefixes into the smallest equivalent list.
+func MergePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	return fromRanges(mergeRanges(toRanges(prefixes)))
+}
+
+// SubtractPrefixes returns the addresses of prefixes that are not in exclude, as merged prefixes.
+func SubtractPrefixes(prefixes, exclude []netip.Prefix) []netip.Prefix {
+	include := mergeRanges(toRanges(prefixes))
+	excluded := mergeRanges(toRanges(exclude))
+	var result []addrRange
+	j := 0
+	for _, r := range include {
+		for j < len(excluded) && excluded[j].to.Less(r.from) {
+			j++
+		}
+		from := r.from
+		covered := false
+		for _, e := range excluded[j:] {
+			if r.to.Less(e.from) {
+				break
+			}
+			if from.Less(e.from) {
+				result = append(result, addrRange{from: from, to: e.from.Prev()})
+			}
+			if !e.to.Less(r.to) {
+				covered = true
+				break
+			}
+			if !e.to.Less(from) {
+				from = e.to.Next()
+			}
+		}
+		if !covered {
+			result = append(result, addrRange{from: from, to: r.to})
+		}
+	}
+	return fromRanges(result)
+}
+
+// PrefixSize returns the number of addresses in p.
+func PrefixSize(p netip.Prefix) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(p.Addr().BitLen()-p.Bits()))
+}
+
+// CountAddrs returns the number of distinct addresses covered by prefixes.
+func CountAddrs(prefixes []netip.Prefix) *big.Int {
+	total := new(big.Int)
+	for _, p := range MergePrefixes(prefixes) {
+		total.Add(total, PrefixSize(p))
+	}
+	return total
+}
+
+// SplitPrefix splits p into the prefixes of length bits it contains. A prefix that is
+// already at least as long is returned unchanged.
+func SplitPrefix(p netip.Prefix, bits int) []netip.Prefix {
+	p = p.Masked()
+	if bits <= p.Bits() || bits > p.Addr().BitLen() {
+		return []netip.Prefix{p}
+	}
+	var prefixes []netip.Prefix
+	last := lastAddr(p)
+	for addr := p.Addr(); addr.IsValid() && !last.Less(addr); {
+		sub := netip.PrefixFrom(addr, bits)
+		prefixes = append(prefixes, sub)
+		addr = lastAddr(sub).Next()
+	}
+	return prefixes
+}
+
+// CoveringPrefix returns the prefix of length bits that contains addr.
+func CoveringPrefix(addr netip.Addr, bits int) netip.Prefix {
+	if bits > addr.BitLen() {
+		bits = addr.BitLen()
+	}
+	p, _ := addr.Prefix(bits)
+	return p
+}
+
+// ParsePrefixes parses a list of prefixes, addresses or address ranges.
+func ParsePrefixes(ss []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range ss {
+		ps, err := ParseRange(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, ps...)
+	}
+	return prefixes, nil
+}
+
+// PrefixStrings formats prefixes, one string each.
+func PrefixStrings(prefixes []netip.Prefix) []string {
+	ss := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		ss = append(ss, p.String())
+	}
+	return ss
+}
+
+// addrAdd returns addr plus offset.
+func addrAdd(addr netip.Addr, offset *big.Int) netip.Addr {
+	sum := new(big.Int).SetBytes(addr.AsSlice())
+	sum.Add(sum, offset)
+	if addr.Is4() {
+		var b [4]byte
+		sum.FillBytes(b[:])
+		return netip.AddrFrom4(b)
+	}
+	var b [16]byte
+	sum.FillBytes(b[:])
+	return netip.AddrFrom16(b)
+}
+
+// SampleAddrs picks up to n distinct random addresses from prefixes.
+func SampleAddrs(prefixes []netip.Prefix, n int, rng *rand.Rand) []netip.Addr {
+	prefixes = MergePrefixes(prefixes)
+	total := CountAddrs(prefixes)
+	if total.Cmp(big.NewInt(int64(n))) <= 0 {
+		var addrs []netip.Addr
+		for _, p := range prefixes {
+			last := lastAddr(p)
+			for addr := p.Addr(); addr.IsValid() && !last.Less(addr); addr = addr.Next() {
+				addrs = append(addrs, addr)
+			}
+		}
+		return addrs
+	}
+	seen := make(map[netip.Addr]bool, n)
+	addrs := make([]netip.Addr, 0, n)
+	for len(addrs) < n {
+		index := new(big.Int).Rand(rng, total)
+		for _, p := range prefixes {
+			size := PrefixSize(p)
+			if index.Cmp(size) < 0 {
+				addr := addrAdd(p.Addr(), index)
+				if !seen[addr] {
+					seen[addr] = true
+					addrs = append(addrs, addr)
+				}
+				break
+			}
+			index.Sub(index, size)
+		}
+	}
+	return addrs
+}
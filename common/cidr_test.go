@@ -0,0 +1,114 @@
+package common
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    []netip.Prefix
+		wantErr bool
+	}{
+		{"192.0.2.7/24", prefixes("192.0.2.0/24"), false},
+		{"192.0.2.7", prefixes("192.0.2.7/32"), false},
+		{"2001:db8::1", prefixes("2001:db8::1/128"), false},
+		{" 10.0.0.1 - 10.0.0.2 ", prefixes("10.0.0.1/32", "10.0.0.2/32"), false},
+		{"0.0.0.0-255.255.255.255", prefixes("0.0.0.0/0"), false},
+		{"10.0.0.2-10.0.0.1", nil, true},
+		{"10.0.0.1-2001:db8::1", nil, true},
+		{"192.0.2.0/33", nil, true},
+		{"example.com", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := ParseRange(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRange(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRange(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRangeToPrefixes(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     []netip.Prefix
+	}{
+		{"10.0.0.1", "10.0.0.5", prefixes("10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31")},
+		{"192.0.2.0", "192.0.2.255", prefixes("192.0.2.0/24")},
+		{"0.0.0.0", "255.255.255.255", prefixes("0.0.0.0/0")},
+		{"255.255.255.255", "255.255.255.255", prefixes("255.255.255.255/32")},
+		{"255.255.255.254", "255.255.255.255", prefixes("255.255.255.254/31")},
+		{"0.0.0.0", "0.0.0.0", prefixes("0.0.0.0/32")},
+		{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", prefixes("::/0")},
+		{"2001:db8::", "2001:db8::2", prefixes("2001:db8::/127", "2001:db8::2/128")},
+		{"10.0.0.5", "10.0.0.1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"-"+tt.to, func(t *testing.T) {
+			got := RangeToPrefixes(netip.MustParseAddr(tt.from), netip.MustParseAddr(tt.to))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RangeToPrefixes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergePrefixes(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []netip.Prefix
+		want []netip.Prefix
+	}{
+		{"adjacent", prefixes("192.0.2.0/25", "192.0.2.128/25"), prefixes("192.0.2.0/24")},
+		{"contained and repeated", prefixes("10.0.0.0/8", "10.1.0.0/16", "10.0.0.0/8"), prefixes("10.0.0.0/8")},
+		{"unmasked", prefixes("192.0.2.7/24"), prefixes("192.0.2.0/24")},
+		{"not adjacent", prefixes("192.0.2.0/24", "198.51.100.0/24"), prefixes("192.0.2.0/24", "198.51.100.0/24")},
+		{"whole ipv4", prefixes("0.0.0.0/1", "128.0.0.0/1", "10.0.0.0/8"), prefixes("0.0.0.0/0")},
+		{"last address", prefixes("255.255.255.255/32", "255.255.255.254/32"), prefixes("255.255.255.254/31")},
+		{"whole ipv6", prefixes("::/0", "2001:db8::/32"), prefixes("::/0")},
+		{"mixed families", prefixes("::/0", "255.255.255.255/32", "0.0.0.0/0"), prefixes("0.0.0.0/0", "::/0")},
+		{"ipv4 end and ipv6 start", prefixes("255.255.255.0/24", "::/128"), prefixes("255.255.255.0/24", "::/128")},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergePrefixes(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergePrefixes(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubtractPrefixes(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude []netip.Prefix
+		want             []netip.Prefix
+	}{
+		{"hole", prefixes("192.0.2.0/24"), prefixes("192.0.2.128/26"), prefixes("192.0.2.0/25", "192.0.2.192/26")},
+		{"nothing left", prefixes("192.0.2.0/24"), prefixes("192.0.0.0/16"), nil},
+		{"disjoint", prefixes("192.0.2.0/24"), prefixes("198.51.100.0/24"), prefixes("192.0.2.0/24")},
+		{"first address", prefixes("0.0.0.0/0"), prefixes("0.0.0.0/32"), RangeToPrefixes(netip.MustParseAddr("0.0.0.1"), netip.MustParseAddr("255.255.255.255"))},
+		{"last address", prefixes("0.0.0.0/0"), prefixes("255.255.255.255/32"), RangeToPrefixes(netip.MustParseAddr("0.0.0.0"), netip.MustParseAddr("255.255.255.254"))},
+		{"everything", prefixes("0.0.0.0/0", "::/0"), prefixes("0.0.0.0/0", "::/0"), nil},
+		{"other family", prefixes("0.0.0.0/0"), prefixes("::/0"), prefixes("0.0.0.0/0")},
+		{"other family ipv6", prefixes("2001:db8::/32"), prefixes("0.0.0.0/0"), prefixes("2001:db8::/32")},
+		{"mixed families", prefixes("10.0.0.0/8", "2001:db8::/32"), prefixes("10.0.0.0/9", "2001:db8:8000::/33"), prefixes("10.128.0.0/9", "2001:db8::/33")},
+		{"several holes", prefixes("10.0.0.0/29"), prefixes("10.0.0.1/32", "10.0.0.3/32", "10.0.0.7/32"), prefixes("10.0.0.0/32", "10.0.0.2/32", "10.0.0.4/31", "10.0.0.6/32")},
+		{"no exclusions", prefixes("::/0"), nil, prefixes("::/0")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SubtractPrefixes(tt.include, tt.exclude); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SubtractPrefixes(%v, %v) = %v, want %v", tt.include, tt.exclude, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,9 +1,13 @@
 package common
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type Site struct {
 	Name               string
+	Provider           string
 	IPRangesAPI        string
 	IPRangesFile       string
 	CustomIPRangesFile string
@@ -11,28 +15,232 @@ type Site struct {
 	WithIPv6           bool
 	HttpsURL           string
 	Domains            []string
+	// Services and Regions narrow down the ranges of providers that publish many services,
+	// e.g. AWS services, Azure service tags or GitHub meta keys.
+	Services []string
+	Regions  []string
+	// Selectors and Pattern describe the ranges document for the Generic provider:
+	// JSONPath-style selectors for JSON, or a regular expression for plain text.
+	Selectors []string
+	Pattern   string
+	// VersionSelector selects the version of a JSON document for the Generic provider,
+	// such as syncToken, so unchanged lists are not rewritten.
+	VersionSelector string
+	// Test the IPs found against every domain, with its own SNI and Host, so each domain
+	// is pointed to its own best IPs.
+	ProbeDomains bool
+	// Ping and HTTP override the global settings of the same name for this site,
+	// such as the alternative HTTPS ports of Cloudflare.
+	Ping PingOverride
+	HTTP HTTPOverride
+}
+
+// PingConfig are the settings of the first test, which only checks that an IP answers.
+type PingConfig struct {
+	Protocol string
+	Port     uint16
+	Count    int
+	Timeout  time.Duration
+	// Pass only if every test succeeds, rather than at least one.
+	All bool
+}
+
+// HTTPConfig are the settings of the https test of the IPs that passed the ping test.
+type HTTPConfig struct {
+	Port    uint16
+	Count   int
+	Timeout time.Duration
+	// Pass only if every request succeeds, rather than at least one.
+	All bool
+}
+
+// PingOverride is the Ping block of a site. Its fields are nil unless they are set.
+type PingOverride struct {
+	Protocol *string
+	Port     *uint16
+	Count    *int
+	Timeout  *time.Duration
+	All      *bool
+}
+
+// HTTPOverride is the HTTP block of a site. Its fields are nil unless they are set.
+type HTTPOverride struct {
+	Port    *uint16
+	Count   *int
+	Timeout *time.Duration
+	All     *bool
+}
+
+// Merge returns base with the fields set in the override replaced.
+func (o PingOverride) Merge(base PingConfig) PingConfig {
+	if o.Protocol != nil {
+		base.Protocol = *o.Protocol
+	}
+	if o.Port != nil {
+		base.Port = *o.Port
+	}
+	if o.Count != nil {
+		base.Count = *o.Count
+	}
+	if o.Timeout != nil {
+		base.Timeout = *o.Timeout
+	}
+	if o.All != nil {
+		base.All = *o.All
+	}
+	return base
+}
+
+// Merge returns base with the fields set in the override replaced.
+func (o HTTPOverride) Merge(base HTTPConfig) HTTPConfig {
+	if o.Port != nil {
+		base.Port = *o.Port
+	}
+	if o.Count != nil {
+		base.Count = *o.Count
+	}
+	if o.Timeout != nil {
+		base.Timeout = *o.Timeout
+	}
+	if o.All != nil {
+		base.All = *o.All
+	}
+	return base
+}
+
+// SiteConfig is a site with the Ping and HTTP settings that apply to it, the global
+// ones merged with the overrides of the site.
+type SiteConfig struct {
+	Site
+	Ping PingConfig
+	HTTP HTTPConfig
 }
 
 type Config struct {
 	General struct {
-		Site         string
+		// The site to scan. A comma separated list, such as "GoogleTranslate,Cloudflare",
+		// scans several sites in one run.
+		Site string
+		// Sites to scan in one run, used instead of Site when it is not empty.
+		Sites        []string
 		Debug        bool
 		Workers      int
 		ScannedLimit int
 		FoundLimit   int
+		// Range files whose addresses are never scanned, on top of the built-in bogons.
+		ExcludeFiles []string
+		// Scan reserved and private ranges too.
+		AllowBogons bool
+		// Skip the IPs that failed in each of the last N scans. 0 disables it.
+		SkipFailedScans int
+		// After a scan, write the prefixes containing the CustomRangesTop fastest IPs to the
+		// CustomIPRangesFile. 0 disables it.
+		CustomRangesTop int
+		// Prefix length of the written ranges, 24 by default.
+		CustomRangesPrefix int
+		// Start at most this many IP tests per second, shared by all sites. 0 is unlimited.
+		RateLimit int
+		// Write the fastest IP to the hosts file after a scan: yes, no, or ask (the default),
+		// which declines when stdin is not a terminal.
+		ApplyHosts string
+		// Path of the hosts file, the one of the operating system by default.
+		HostsFile string
+		// Number of IPs written to the hosts file for every domain, for resolvers that
+		// round-robin. 1 by default.
+		HostsIPs int
+		// Formats to export the best IPs of every domain to after a scan, next to IPOutputFile:
+		// dnsmasq, unbound, coredns, adguard, pihole, clash, singbox or endpoints.
+		Exports []string
+		// Shell command run after exporting, such as "systemctl reload dnsmasq".
+		ReloadCmd string
+		// File the Prometheus metrics are written to after a scan or a fetch, for the
+		// textfile collector of the node exporter.
+		MetricsFile string
+	}
+	Ping PingConfig
+	HTTP HTTPConfig
+	// Daemon configures the daemon command, which keeps the hosts file and the exports
+	// pointed to healthy IPs.
+	Daemon struct {
+		// How often the applied IPs are checked, 60s by default. Milliseconds or a duration such as "1m".
+		CheckInterval time.Duration
+		// How often every site is scanned again, 6h by default.
+		RescanInterval time.Duration
+		// The applied IPs fail a check if their HttpRTT is above this, in milliseconds. 0 disables it.
+		LatencySLO int
+		// Consecutive failed checks before a site is scanned again, 3 by default.
+		FailedChecks int
+		// A scan only replaces healthy IPs if its best IP is faster by this percentage, 20 by default.
+		MinImprovement int
+		// Address to serve /metrics and /status on, such as 127.0.0.1:9090. Off when empty.
+		MetricsListen string
 	}
-	Ping struct {
-		Protocol string
-		Port     uint16
-		Count    int
-		Timeout  time.Duration
-		all      bool
+	// DNS configures the dns command, a resolver answering the domains of the sites
+	// with their best IPs.
+	DNS struct {
+		// Address to listen on for UDP and TCP, 127.0.0.1:53 by default.
+		Listen string
+		// Resolver that the queries of other domains are forwarded to, 1.1.1.1:53 by default.
+		Upstream string
+		// TTL of the answers in seconds, 60 by default.
+		TTL int
 	}
-	HTTP struct {
-		Port    uint16
-		Count   int
-		Timeout time.Duration
-		all     bool
+	// Proxy configures the proxy command, which forwards TLS connections to the best IPs
+	// of the site of their server name.
+	Proxy struct {
+		// Address to listen on, 127.0.0.1:8443 by default.
+		Listen string
+	}
+	// API configures the serve command, an HTTP API and dashboard of the scans.
+	API struct {
+		// Address to listen on, 127.0.0.1:8080 by default.
+		Listen string
 	}
 	Sites []Site
+	// UnknownKeys are the keys of the configuration file that match no field,
+	// usually typos. They are filled in by the loader and reported by Validate.
+	UnknownKeys []string `mapstructure:"-"`
+}
+
+// SelectedSites returns the names of the sites to work on, from General.Sites or else
+// General.Site.
+func (config *Config) SelectedSites() []string {
+	if len(config.General.Sites) > 0 {
+		return config.General.Sites
+	}
+	var names []string
+	for _, name := range strings.Split(config.General.Site, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ForSite returns a copy of the configuration that selects only the named site, for the
+// functions that work on the site of General.Site.
+func (config *Config) ForSite(name string) *Config {
+	siteConfig := *config
+	siteConfig.General.Site = name
+	siteConfig.General.Sites = nil
+	return &siteConfig
+}
+
+// SiteByName returns the site configured under Sites with the name, resolved against
+// the global Ping and HTTP settings.
+func (config *Config) SiteByName(name string) (SiteConfig, bool) {
+	for _, site := range config.Sites {
+		if site.Name == name {
+			return config.resolve(site), true
+		}
+	}
+	return SiteConfig{}, false
+}
+
+func (config *Config) resolve(site Site) SiteConfig {
+	return SiteConfig{
+		Site: site,
+		Ping: site.Ping.Merge(config.Ping),
+		HTTP: site.HTTP.Merge(config.HTTP),
+	}
 }
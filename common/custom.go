@@ -0,0 +1,54 @@
+package common
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// writeCustomRanges replaces the CustomIPRangesFile with the prefixes containing the
+// General.CustomRangesTop fastest IPs, so the next scan only probes proven subnets.
+// Each line notes which IPs put the prefix there.
+func writeCustomRanges(scanRecords ScanRecordArray, config *Config) {
+	top := config.General.CustomRangesTop
+	if top <= 0 || len(scanRecords) == 0 {
+		return
+	}
+	bits := config.General.CustomRangesPrefix
+	if bits <= 0 {
+		bits = 24
+	}
+	siteCfg := RetrieveSiteCfg(config)
+	if len(scanRecords) > top {
+		scanRecords = scanRecords[:top]
+	}
+	var prefixes []netip.Prefix
+	provenance := make(map[netip.Prefix][]string)
+	for _, record := range scanRecords {
+		addr, err := netip.ParseAddr(record.Addr())
+		if err != nil {
+			continue
+		}
+		p := CoveringPrefix(addr, bits)
+		if _, ok := provenance[p]; !ok {
+			prefixes = append(prefixes, p)
+		}
+		provenance[p] = append(provenance[p], fmt.Sprintf("%s ping %.fms http %.fms", addr, record.PingRTT, record.HttpRTT))
+	}
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "# Generated by ip-scanner for %s at %s\n", siteCfg.Name, time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&builder, "# The /%d prefixes of the %d fastest IPs, delete this file to scan all IP ranges again.\n",
+		bits, len(scanRecords))
+	for _, p := range prefixes {
+		fmt.Fprintf(&builder, "%s\t# %s\n", p, strings.Join(provenance[p], ", "))
+	}
+	err := WriteFileAtomic(siteCfg.CustomIPRangesFile, []byte(builder.String()), 0644)
+	if err != nil {
+		slog.Error("write custom ip ranges file failed", "file", siteCfg.CustomIPRangesFile, "error", err)
+		return
+	}
+	slog.Info("Custom ip ranges file written from the fastest IPs:", "file", siteCfg.CustomIPRangesFile,
+		"prefixes", len(prefixes))
+}
@@ -0,0 +1,217 @@
+package common
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Defaults of the Daemon section.
+const (
+	defaultCheckInterval  = time.Minute
+	defaultRescanInterval = 6 * time.Hour
+	defaultFailedChecks   = 3
+	defaultMinImprovement = 20
+)
+
+// daemonSite is the state of a site in the daemon: the records and entries applied to the
+// hosts file and the exports, and how they did in the last checks.
+type daemonSite struct {
+	config  *Config
+	site    SiteConfig
+	records ScanRecordArray
+	entries []HostsEntry
+	// rtt is the HttpRTT of the applied IPs in the last check, 0 if unknown.
+	rtt float64
+	// failures counts the consecutive failed checks.
+	failures int
+}
+
+// RunDaemon keeps the hosts file and the exports of the selected sites pointed to healthy
+// IPs until ctx is done. It checks the applied IPs every CheckInterval with the ping and
+// http tests, and scans a site again after FailedChecks failed checks in a row. Every site
+// is scanned again every RescanInterval. To avoid flapping, a scan only replaces healthy
+// IPs if its best IP is faster by MinImprovement percent.
+func RunDaemon(ctx context.Context, config *Config) error {
+	daemon := config.Daemon
+	checkInterval, rescanInterval := daemon.CheckInterval, daemon.RescanInterval
+	if checkInterval <= 0 {
+		checkInterval = defaultCheckInterval
+	}
+	if rescanInterval <= 0 {
+		rescanInterval = defaultRescanInterval
+	}
+	if config.General.ApplyHosts != ApplyHostsYes && len(config.General.Exports) == 0 {
+		slog.Warn("Neither ApplyHosts = \"yes\" nor Exports are configured, the daemon only scans and checks")
+	}
+	var sites []*daemonSite
+	var stale []string
+	for _, name := range config.SelectedSites() {
+		siteConfig := config.ForSite(name)
+		state := &daemonSite{config: siteConfig, site: RetrieveSiteCfg(siteConfig)}
+		if scanRecords, err := LoadResults(state.site.Site); err == nil && len(scanRecords) > 0 {
+			state.records = scanRecords
+			state.entries = HostsEntries(scanRecords, siteConfig)
+		}
+		if len(state.entries) == 0 {
+			stale = append(stale, name)
+		}
+		sites = append(sites, state)
+	}
+	slog.Info("Daemon started:", "Sites", len(sites), "CheckInterval", checkInterval, "RescanInterval", rescanInterval)
+	// Make sure the IPs of the last scans are applied, like after a restart.
+	exported := false
+	for _, state := range sites {
+		if len(state.entries) > 0 && state.apply() {
+			exported = true
+		}
+	}
+	if exported {
+		if err := RunReloadCmd(config.General.ReloadCmd); err != nil {
+			slog.Error("Reload failed:", "error", err)
+		}
+	}
+	if len(stale) > 0 {
+		rescan(ctx, config, sites, stale)
+	}
+	checkTicker := time.NewTicker(checkInterval)
+	defer checkTicker.Stop()
+	rescanTicker := time.NewTicker(rescanInterval)
+	defer rescanTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Daemon stopped")
+			return nil
+		case <-rescanTicker.C:
+			rescan(ctx, config, sites, config.SelectedSites())
+		case <-checkTicker.C:
+			var failing []string
+			for _, state := range sites {
+				if !state.check() {
+					failing = append(failing, state.site.Name)
+				}
+			}
+			if len(failing) > 0 {
+				rescan(ctx, config, sites, failing)
+			}
+		}
+	}
+}
+
+// check tests the applied IPs of the site and reports false once they failed FailedChecks
+// checks in a row, so the site needs to be scanned again.
+func (state *daemonSite) check() bool {
+	if len(state.entries) == 0 {
+		return false
+	}
+	healthy := false
+	state.rtt = 0
+	for _, entry := range state.entries {
+		url := state.site.HttpsURL
+		if state.site.ProbeDomains {
+			url = "https://" + entry.Domain + "/"
+		}
+		record := new(ScanRecord)
+		if !pingOneIP(entry.IP, state.site, record) || !reqOneIP(entry.IP, state.site, url, record) {
+			slog.Info("Check failed:", "Site", state.site.Name, "IP", entry.IP, "Domain", entry.Domain)
+			continue
+		}
+		slo := state.config.Daemon.LatencySLO
+		if slo > 0 && record.HttpRTT > float64(slo) {
+			slog.Info("Check over the latency SLO:", "Site", state.site.Name, "IP", entry.IP, "HttpRTT", record.HttpRTT, "SLO", slo)
+			continue
+		}
+		healthy = true
+		if state.rtt == 0 || record.HttpRTT < state.rtt {
+			state.rtt = record.HttpRTT
+		}
+	}
+	if healthy {
+		state.failures = 0
+		return true
+	}
+	state.failures++
+	limit := state.config.Daemon.FailedChecks
+	if limit <= 0 {
+		limit = defaultFailedChecks
+	}
+	slog.Warn("The applied IPs are unhealthy:", "Site", state.site.Name, "FailedChecks", state.failures, "Limit", limit)
+	return state.failures < limit
+}
+
+// rescan scans the named sites and applies the IPs found where they are better enough.
+func rescan(ctx context.Context, config *Config, sites []*daemonSite, names []string) {
+	slog.Info("Daemon scanning:", "Sites", names)
+	scans, err := scanSites(ctx, config, names)
+	if err != nil {
+		slog.Error("Daemon scan failed, keep the applied IPs:", "error", err)
+		return
+	}
+	exported := false
+	for _, scan := range scans {
+		for _, state := range sites {
+			if state.site.Name == scan.site.Name && state.update(scan.result.scanRecords) {
+				exported = true
+			}
+		}
+	}
+	if exported {
+		if err := RunReloadCmd(config.General.ReloadCmd); err != nil {
+			slog.Error("Reload failed:", "error", err)
+		}
+	}
+}
+
+// update applies the records of a new scan if the applied IPs are unhealthy, or if the
+// new best IP is faster by MinImprovement percent. Only applied records are written to the
+// IPOutputFile, which the dns and proxy commands follow. The CustomIPRangesFile is left
+// alone. It reports whether exports were written.
+func (state *daemonSite) update(scanRecords ScanRecordArray) bool {
+	if len(scanRecords) == 0 {
+		slog.Warn("The scan found no IP, keep the applied IPs:", "Site", state.site.Name)
+		return false
+	}
+	improvement := state.config.Daemon.MinImprovement
+	if improvement <= 0 {
+		improvement = defaultMinImprovement
+	}
+	best := scanRecords[0].HttpRTT
+	healthy := len(state.entries) > 0 && state.failures == 0 && state.rtt > 0
+	if healthy && best > state.rtt*float64(100-improvement)/100 {
+		slog.Info("Keep the applied IPs, the scan is not faster enough:", "Site", state.site.Name,
+			"AppliedRTT", state.rtt, "BestRTT", best, "MinImprovement", improvement)
+		return false
+	}
+	state.records = scanRecords
+	state.entries = HostsEntries(scanRecords, state.config)
+	state.rtt = best
+	state.failures = 0
+	if len(state.entries) == 0 {
+		return false
+	}
+	slog.Info("Applying the new best IPs:", "Site", state.site.Name, "IP", state.entries[0].IP, "HttpRTT", best)
+	writeToFile(scanRecords, state.config)
+	return state.apply()
+}
+
+// apply writes the entries of the site to the hosts file and the exports.
+func (state *daemonSite) apply() bool {
+	return applyResults(state.records, state.entries, state.config)
+}
+
+// applyResults writes entries to the hosts file, with ApplyHosts = "yes", and the records
+// and entries to the exports, without asking. It reports whether exports were written.
+func applyResults(scanRecords ScanRecordArray, entries []HostsEntry, config *Config) bool {
+	if config.General.ApplyHosts == ApplyHostsYes {
+		site := RetrieveSiteCfg(config)
+		hostsFile, err := HostsPath(config)
+		if err == nil {
+			err = WriteToHosts(hostsFile, site.Name, entries)
+		}
+		if err != nil {
+			slog.Error("Modify hosts failed:", "Site", site.Name, "error", err)
+		}
+	}
+	return writeExports(scanRecords, entries, config)
+}
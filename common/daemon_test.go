@@ -0,0 +1,118 @@
+package common
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newDaemonSite returns the state of a site whose applied IP is 192.0.2.1 with rtt, and
+// whose output files are in a temporary directory.
+func newDaemonSite(t *testing.T, rtt float64) *daemonSite {
+	t.Helper()
+	config := new(Config)
+	config.General.Site = "Test"
+	config.Ping = PingConfig{Protocol: "tcp", Count: 1, Timeout: time.Second}
+	config.HTTP = HTTPConfig{Count: 1, Timeout: time.Second}
+	config.Sites = []Site{{
+		Name:         "Test",
+		IPOutputFile: filepath.Join(t.TempDir(), "ip.txt"),
+		HttpsURL:     "https://test.example/",
+		Domains:      []string{"test.example"},
+	}}
+	records := ScanRecordArray{{IP: "192.0.2.1", HttpRTT: rtt}}
+	return &daemonSite{
+		config:  config,
+		site:    RetrieveSiteCfg(config),
+		records: records,
+		entries: HostsEntries(records, config),
+		rtt:     rtt,
+	}
+}
+
+func TestDaemonSiteUpdate(t *testing.T) {
+	tests := []struct {
+		name           string
+		minImprovement int
+		failures       int
+		scan           ScanRecordArray
+		wantIP         string
+	}{
+		{"faster by the default 20%", 0, 0, ScanRecordArray{{IP: "192.0.2.2", HttpRTT: 79}}, "192.0.2.2"},
+		{"not faster enough", 0, 0, ScanRecordArray{{IP: "192.0.2.2", HttpRTT: 81}}, "192.0.2.1"},
+		{"slower", 0, 0, ScanRecordArray{{IP: "192.0.2.2", HttpRTT: 150}}, "192.0.2.1"},
+		{"faster by the configured 50%", 50, 0, ScanRecordArray{{IP: "192.0.2.2", HttpRTT: 49}}, "192.0.2.2"},
+		{"not faster by the configured 50%", 50, 0, ScanRecordArray{{IP: "192.0.2.2", HttpRTT: 60}}, "192.0.2.1"},
+		{"unhealthy is replaced even if slower", 0, 1, ScanRecordArray{{IP: "192.0.2.2", HttpRTT: 150}}, "192.0.2.2"},
+		{"empty scan keeps the applied IPs", 0, 1, nil, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := newDaemonSite(t, 100)
+			state.config.Daemon.MinImprovement = tt.minImprovement
+			state.failures = tt.failures
+			state.update(tt.scan)
+			if got := state.entries[0].IP; got != tt.wantIP {
+				t.Errorf("applied IP = %s, want %s", got, tt.wantIP)
+			}
+			// Only the applied records are written to the output file.
+			data, err := os.ReadFile(state.site.IPOutputFile)
+			switch {
+			case tt.wantIP == "192.0.2.1" && !os.IsNotExist(err):
+				t.Errorf("output file written although the IPs were kept: %q, %v", data, err)
+			case tt.wantIP != "192.0.2.1" && string(data) != tt.wantIP+"\n":
+				t.Errorf("output file = %q, %v, want %q", data, err, tt.wantIP+"\n")
+			}
+			if tt.wantIP != "192.0.2.1" && state.failures != 0 {
+				t.Errorf("failures = %d after applying new IPs, want 0", state.failures)
+			}
+		})
+	}
+}
+
+func TestDaemonSiteCheckFailedChecks(t *testing.T) {
+	// A port that refuses connections, so every check fails.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+	for _, limit := range []int{0, 1, 2} {
+		state := newDaemonSite(t, 100)
+		state.config.Daemon.FailedChecks = limit
+		state.site.Ping.Port = port
+		state.entries = []HostsEntry{{IP: "127.0.0.1", Domain: "test.example"}}
+		want := limit
+		if want == 0 {
+			want = defaultFailedChecks
+		}
+		for i := 1; i <= want; i++ {
+			got := state.check()
+			if got != (i < want) {
+				t.Errorf("FailedChecks %d: check %d = %v, want %v", limit, i, got, i < want)
+			}
+			if state.failures != i {
+				t.Errorf("FailedChecks %d: failures = %d after check %d", limit, state.failures, i)
+			}
+		}
+		if state.rtt != 0 {
+			t.Errorf("FailedChecks %d: rtt = %v after failed checks, want 0", limit, state.rtt)
+		}
+		// After the streak the applied IPs count as unhealthy, so a slower scan replaces them.
+		state.update(ScanRecordArray{{IP: "192.0.2.2", HttpRTT: 500}})
+		if state.entries[0].IP != "192.0.2.2" || state.failures != 0 {
+			t.Errorf("FailedChecks %d: update after the streak applied %s with %d failures", limit, state.entries[0].IP, state.failures)
+		}
+	}
+}
+
+func TestDaemonSiteCheckWithoutEntries(t *testing.T) {
+	state := newDaemonSite(t, 100)
+	state.entries = nil
+	if state.check() {
+		t.Error("check() = true without applied IPs, want a rescan")
+	}
+}
@@ -0,0 +1,283 @@
+package common
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsTimeout bounds a query to the upstream resolver and the reads of a tcp client.
+const dnsTimeout = 5 * time.Second
+
+// DNSServer answers the A and AAAA queries of the domains of the sites with their best IPs
+// over UDP and TCP, and forwards every other query to an upstream resolver.
+type DNSServer struct {
+	Listen   string
+	Upstream string
+	TTL      uint32
+
+	mutex   sync.RWMutex
+	records map[string][]netip.Addr
+}
+
+// SetRecords replaces the answers of the server. The domains of entries are answered with
+// their IPs, in order; the other domains are forwarded.
+func (server *DNSServer) SetRecords(entries []HostsEntry) {
+	records := make(map[string][]netip.Addr)
+	for _, entry := range entries {
+		addr, err := netip.ParseAddr(entry.IP)
+		if err != nil {
+			slog.Warn("invalid ip, not served", "IP", entry.IP, "Domain", entry.Domain)
+			continue
+		}
+		name := canonicalName(entry.Domain)
+		records[name] = append(records[name], addr)
+	}
+	server.mutex.Lock()
+	server.records = records
+	server.mutex.Unlock()
+}
+
+func canonicalName(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, ".")) + "."
+}
+
+// ListenAndServe serves UDP and TCP on Listen until ctx is done.
+func (server *DNSServer) ListenAndServe(ctx context.Context) error {
+	packetConn, listener, err := server.listen()
+	if err != nil {
+		return err
+	}
+	return server.serve(ctx, packetConn, listener)
+}
+
+// listen listens on Listen for UDP, and for TCP on the same port, which is the one picked
+// for UDP if Listen has port 0.
+func (server *DNSServer) listen() (net.PacketConn, net.Listener, error) {
+	packetConn, err := net.ListenPacket("udp", server.Listen)
+	if err != nil {
+		return nil, nil, err
+	}
+	listener, err := net.Listen("tcp", packetConn.LocalAddr().String())
+	if err != nil {
+		packetConn.Close()
+		return nil, nil, err
+	}
+	return packetConn, listener, nil
+}
+
+func (server *DNSServer) serve(ctx context.Context, packetConn net.PacketConn, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		packetConn.Close()
+		listener.Close()
+	}()
+	slog.Info("DNS server listening:", "Address", packetConn.LocalAddr(), "Upstream", server.Upstream)
+	errs := make(chan error, 2)
+	go func() { errs <- server.serveUDP(packetConn) }()
+	go func() { errs <- server.serveTCP(listener) }()
+	err := errors.Join(<-errs, <-errs)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func (server *DNSServer) serveUDP(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			if response := server.handle("udp", query); response != nil {
+				if _, err := conn.WriteTo(response, addr); err != nil {
+					slog.Debug("dns write failed", "error", err)
+				}
+			}
+		}()
+	}
+}
+
+func (server *DNSServer) serveTCP(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				conn.SetDeadline(time.Now().Add(dnsTimeout))
+				query, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				response := server.handle("tcp", query)
+				if response == nil || writeTCPMessage(conn, response) != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// handle answers a query from the records, or forwards it to the upstream resolver over
+// network. It returns nil if the query cannot even be parsed.
+func (server *DNSServer) handle(network string, query []byte) []byte {
+	response, err := server.answer(query)
+	if err != nil {
+		slog.Debug("invalid dns query", "error", err)
+		return nil
+	}
+	if response != nil {
+		return response
+	}
+	response, err = forwardDNS(network, server.Upstream, query)
+	if err != nil {
+		slog.Warn("dns forward failed", "Upstream", server.Upstream, "error", err)
+		response, _ = errorResponse(query, dnsmessage.RCodeServerFailure)
+	}
+	return response
+}
+
+// answer returns the response to a query of a served domain, or nil for other domains.
+// A served domain has no records besides its A and AAAA ones.
+func (server *DNSServer) answer(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+	server.mutex.RLock()
+	addrs, ok := server.records[strings.ToLower(question.Name.String())]
+	server.mutex.RUnlock()
+	if !ok || question.Class != dnsmessage.ClassINET {
+		return nil, nil
+	}
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 header.ID,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   header.RecursionDesired,
+		RecursionAvailable: true,
+	})
+	builder.EnableCompression()
+	if err = builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err = builder.Question(question); err != nil {
+		return nil, err
+	}
+	if err = builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		resource := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: server.TTL}
+		switch {
+		case question.Type == dnsmessage.TypeA && addr.Is4():
+			err = builder.AResource(resource, dnsmessage.AResource{A: addr.As4()})
+		case question.Type == dnsmessage.TypeAAAA && addr.Is6():
+			err = builder.AAAAResource(resource, dnsmessage.AAAAResource{AAAA: addr.As16()})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
+
+// errorResponse returns an empty response to query with rcode.
+func errorResponse(query []byte, rcode dnsmessage.RCode) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 header.ID,
+		Response:           true,
+		RecursionDesired:   header.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              rcode,
+	})
+	if err = builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, question := range questions {
+		if err = builder.Question(question); err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
+
+// forwardDNS sends query to upstream over network, udp or tcp, and returns its response.
+func forwardDNS(network string, upstream string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(network, upstream, dnsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(dnsTimeout)); err != nil {
+		return nil, err
+	}
+	if network == "tcp" {
+		if err = writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// readTCPMessage reads a DNS message prefixed by its two bytes length.
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func writeTCPMessage(w io.Writer, message []byte) error {
+	if len(message) > 65535 {
+		return fmt.Errorf("dns message of %d bytes is too long", len(message))
+	}
+	buf := make([]byte, 2+len(message))
+	binary.BigEndian.PutUint16(buf, uint16(len(message)))
+	copy(buf[2:], message)
+	_, err := w.Write(buf)
+	return err
+}
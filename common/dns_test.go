@@ -0,0 +1,243 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// stubUpstream answers every query over UDP and TCP with a fixed A record and remembers
+// the last response it sent, so a test can check that it is passed on unchanged.
+type stubUpstream struct {
+	addr      string
+	responses chan []byte
+}
+
+func startStubUpstream(t *testing.T, ctx context.Context) *stubUpstream {
+	t.Helper()
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", packetConn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &stubUpstream{addr: packetConn.LocalAddr().String(), responses: make(chan []byte, 4)}
+	go func() {
+		<-ctx.Done()
+		packetConn.Close()
+		listener.Close()
+	}()
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := packetConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			packetConn.WriteTo(stub.respond(t, buf[:n]), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			query, err := readTCPMessage(conn)
+			if err == nil {
+				writeTCPMessage(conn, stub.respond(t, query))
+			}
+			conn.Close()
+		}
+	}()
+	return stub
+}
+
+func (stub *stubUpstream) respond(t *testing.T, query []byte) []byte {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	question, err := parser.Question()
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	response, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: header.ID, Response: true, RecursionAvailable: true},
+		Questions: []dnsmessage.Question{question},
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 1234},
+			Body:   &dnsmessage.AResource{A: [4]byte{203, 0, 113, 7}},
+		}},
+	}).Pack()
+	if err != nil {
+		t.Error(err)
+	}
+	stub.responses <- response
+	return response
+}
+
+func startDNSServer(t *testing.T, ctx context.Context, upstream string) string {
+	t.Helper()
+	server := &DNSServer{Listen: "127.0.0.1:0", Upstream: upstream, TTL: 60}
+	server.SetRecords([]HostsEntry{
+		{IP: "142.250.4.90", Domain: "translate.googleapis.com"},
+		{IP: "142.250.4.91", Domain: "translate.googleapis.com"},
+		{IP: "2404:6800:4008:c00::5a", Domain: "translate.googleapis.com"},
+	})
+	packetConn, listener, err := server.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.serve(ctx, packetConn, listener)
+	return packetConn.LocalAddr().String()
+}
+
+func exchange(t *testing.T, network string, addr string, name string, qtype dnsmessage.Type) []byte {
+	t.Helper()
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 4242, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialTimeout(network, addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if network == "tcp" {
+		if err = writeTCPMessage(conn, query); err != nil {
+			t.Fatal(err)
+		}
+		response, err := readTCPMessage(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	if _, err = conn.Write(query); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+// answerAddrs returns the A and AAAA answers of response.
+func answerAddrs(t *testing.T, response []byte) (dnsmessage.Header, []netip.Addr) {
+	t.Helper()
+	var message dnsmessage.Message
+	if err := message.Unpack(response); err != nil {
+		t.Fatal(err)
+	}
+	var addrs []netip.Addr
+	for _, answer := range message.Answers {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, netip.AddrFrom4(body.A))
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, netip.AddrFrom16(body.AAAA))
+		}
+		if answer.Header.TTL != 60 {
+			t.Errorf("TTL = %d, want 60", answer.Header.TTL)
+		}
+	}
+	return message.Header, addrs
+}
+
+func TestDNSServerAnswersServedNames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stub := startStubUpstream(t, ctx)
+	addr := startDNSServer(t, ctx, stub.addr)
+	tests := []struct {
+		network string
+		name    string
+		qtype   dnsmessage.Type
+		want    []string
+	}{
+		{"udp", "translate.googleapis.com.", dnsmessage.TypeA, []string{"142.250.4.90", "142.250.4.91"}},
+		{"udp", "Translate.GoogleAPIs.com.", dnsmessage.TypeA, []string{"142.250.4.90", "142.250.4.91"}},
+		{"udp", "translate.googleapis.com.", dnsmessage.TypeAAAA, []string{"2404:6800:4008:c00::5a"}},
+		{"tcp", "translate.googleapis.com.", dnsmessage.TypeA, []string{"142.250.4.90", "142.250.4.91"}},
+		// A served name has no other records.
+		{"udp", "translate.googleapis.com.", dnsmessage.TypeMX, nil},
+	}
+	for _, tt := range tests {
+		header, addrs := answerAddrs(t, exchange(t, tt.network, addr, tt.name, tt.qtype))
+		if !header.Authoritative || header.RCode != dnsmessage.RCodeSuccess || header.ID != 4242 {
+			t.Errorf("%s %s %v: header = %+v", tt.network, tt.name, tt.qtype, header)
+		}
+		var got []string
+		for _, a := range addrs {
+			got = append(got, a.String())
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s %s %v: answers = %v, want %v", tt.network, tt.name, tt.qtype, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s %s %v: answers = %v, want %v", tt.network, tt.name, tt.qtype, got, tt.want)
+			}
+		}
+	}
+	select {
+	case <-stub.responses:
+		t.Error("a served name was forwarded to the upstream")
+	default:
+	}
+}
+
+func TestDNSServerForwardsOtherNames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stub := startStubUpstream(t, ctx)
+	addr := startDNSServer(t, ctx, stub.addr)
+	for _, network := range []string{"udp", "tcp"} {
+		response := exchange(t, network, addr, "example.org.", dnsmessage.TypeA)
+		select {
+		case sent := <-stub.responses:
+			if !bytes.Equal(response, sent) {
+				t.Errorf("%s: response = %x, want the upstream's %x", network, response, sent)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: the query was not forwarded", network)
+		}
+	}
+}
+
+func TestDNSServerUpstreamDown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Nothing listens on the upstream, so the forward fails.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := listener.Addr().String()
+	listener.Close()
+	addr := startDNSServer(t, ctx, upstream)
+	var message dnsmessage.Message
+	if err = message.Unpack(exchange(t, "tcp", addr, "example.org.", dnsmessage.TypeA)); err != nil {
+		t.Fatal(err)
+	}
+	if message.Header.RCode != dnsmessage.RCodeServerFailure {
+		t.Errorf("RCode = %v, want SERVFAIL", message.Header.RCode)
+	}
+}
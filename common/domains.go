@@ -0,0 +1,97 @@
+package common
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// maxDomainCandidates is the number of fastest IPs of a scan tested against every domain.
+const maxDomainCandidates = 20
+
+// HostsEntry points a domain to an IP in the hosts file.
+type HostsEntry struct {
+	IP     string
+	Domain string
+}
+
+// HostsEntries returns the hosts entries of the site of config for the records of a
+// scan, fastest first: the General.HostsIPs best IPs of every domain. With ProbeDomains,
+// the fastest IPs are tested against each domain, with its own SNI and Host, and every
+// domain gets its own best IPs. Otherwise every domain gets the best IPs of the scan.
+func HostsEntries(scanRecords ScanRecordArray, config *Config) []HostsEntry {
+	site := RetrieveSiteCfg(config)
+	n := config.General.HostsIPs
+	if n <= 0 {
+		n = 1
+	}
+	var byDomain map[string]ScanRecordArray
+	if site.ProbeDomains {
+		byDomain = probeDomains(scanRecords, site, config.General.Workers)
+	}
+	var entries []HostsEntry
+	for _, domain := range site.Domains {
+		records := scanRecords
+		if site.ProbeDomains {
+			records = byDomain[domain]
+			if len(records) == 0 {
+				slog.Warn("No IP passed the test of the domain, it is left out of the hosts file", "Domain", domain)
+				continue
+			}
+		}
+		seen := make(map[string]bool)
+		for _, record := range records {
+			if len(seen) == n {
+				break
+			}
+			ip := record.Addr()
+			if seen[ip] {
+				continue
+			}
+			seen[ip] = true
+			entries = append(entries, HostsEntry{IP: ip, Domain: domain})
+		}
+	}
+	return entries
+}
+
+// probeDomains tests the fastest records against https://<domain>/ for every domain of the
+// site and returns the records that passed for each domain, fastest first.
+func probeDomains(scanRecords ScanRecordArray, site SiteConfig, workers int) map[string]ScanRecordArray {
+	candidates := scanRecords
+	if len(candidates) > maxDomainCandidates {
+		candidates = candidates[:maxDomainCandidates]
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	byDomain := make(map[string]ScanRecordArray)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for _, domain := range site.Domains {
+		for _, candidate := range candidates {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(domain string, candidate *ScanRecord) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				record := &ScanRecord{IP: candidate.IP, Protocol: candidate.Protocol, PingRTT: candidate.PingRTT}
+				if !reqOneIP(candidate.Addr(), site, "https://"+domain+"/", record) {
+					slog.Debug("IP failed the test of the domain", "IP", candidate.IP, "Domain", domain)
+					return
+				}
+				mutex.Lock()
+				byDomain[domain] = append(byDomain[domain], record)
+				mutex.Unlock()
+			}(domain, candidate)
+		}
+	}
+	wg.Wait()
+	for _, records := range byDomain {
+		sort.Slice(records, func(i, j int) bool {
+			return records[i].HttpRTT < records[j].HttpRTT
+		})
+	}
+	return byDomain
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"log/slog"
+	"math/big"
+	"net/netip"
+)
+
+// bogons are the reserved, private, shared, multicast and documentation ranges.
+// They are never scanned unless General.AllowBogons is set.
+var bogons = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/8",
+	"100::/64",
+	"2001:2::/48",
+	"2001:10::/28",
+	"2001:db8::/32",
+	"3ffe::/16",
+	"fc00::/7",
+	"fe80::/10",
+	"fec0::/10",
+	"ff00::/8",
+}
+
+// excludedPrefixes collects everything that must never be scanned for the current site:
+// the bogons, the ranges of General.ExcludeFiles, and the IPs that failed in the last
+// General.SkipFailedScans scans.
+func excludedPrefixes(config *Config) ([]netip.Prefix, error) {
+	var exclude []netip.Prefix
+	if !config.General.AllowBogons {
+		for _, bogon := range bogons {
+			exclude = append(exclude, netip.MustParsePrefix(bogon))
+		}
+	}
+	for _, file := range config.General.ExcludeFiles {
+		entries, err := ParseRangesFile(file)
+		if err != nil {
+			return nil, err
+		}
+		// Every line of an exclude file is excluded, with or without the ! mark.
+		for _, entry := range entries {
+			exclude = append(exclude, entry.Prefixes...)
+		}
+	}
+	if n := config.General.SkipFailedScans; n > 0 {
+		history, err := LoadFailureHistory(RetrieveSiteCfg(config).Site)
+		if err != nil {
+			return nil, err
+		}
+		failed := history.Failed(n)
+		slog.Info("Skip IPs failed in the last scans:", "Scans", n, "Count", len(failed))
+		exclude = append(exclude, failed...)
+	}
+	return exclude, nil
+}
+
+func countGroups(groups []RangeGroup) *big.Int {
+	var prefixes []netip.Prefix
+	for _, group := range groups {
+		prefixes = append(prefixes, group.Prefixes...)
+	}
+	return CountAddrs(prefixes)
+}
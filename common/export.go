@@ -0,0 +1,220 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exporter renders the results of a site in the configuration format of a local resolver
+// or a proxy client.
+type exporter struct {
+	// ext is appended to IPOutputFile to name the exported file.
+	ext    string
+	render func(input exportInput) string
+	// noHeader is set for formats without comments, such as JSON.
+	noHeader bool
+}
+
+// exportInput is what exporters render: the records of the last scan, fastest first, and
+// the hosts entries of the domains, with the https port the records were tested on.
+type exportInput struct {
+	records ScanRecordArray
+	entries []HostsEntry
+	port    uint16
+}
+
+var exporters = map[string]exporter{
+	// dnsmasq: address=/translate.googleapis.com/142.250.4.90
+	"dnsmasq": {ext: ".dnsmasq.conf", render: func(input exportInput) string {
+		var builder strings.Builder
+		for _, entry := range input.entries {
+			fmt.Fprintf(&builder, "address=/%s/%s\n", entry.Domain, entry.IP)
+		}
+		return builder.String()
+	}},
+	// unbound: local-data: "translate.googleapis.com. A 142.250.4.90", to include in unbound.conf.
+	"unbound": {ext: ".unbound.conf", render: func(input exportInput) string {
+		var builder strings.Builder
+		builder.WriteString("server:\n")
+		for _, entry := range input.entries {
+			recordType := "A"
+			if addr, err := netip.ParseAddr(entry.IP); err == nil && addr.Is6() {
+				recordType = "AAAA"
+			}
+			fmt.Fprintf(&builder, "    local-data: \"%s. %s %s\"\n", entry.Domain, recordType, entry.IP)
+		}
+		return builder.String()
+	}},
+	// coredns: a hosts block for the Corefile, other names fall through to the next plugin.
+	"coredns": {ext: ".coredns", render: func(input exportInput) string {
+		var builder strings.Builder
+		builder.WriteString("hosts {\n")
+		for _, entry := range input.entries {
+			fmt.Fprintf(&builder, "    %s %s\n", entry.IP, entry.Domain)
+		}
+		builder.WriteString("    fallthrough\n}\n")
+		return builder.String()
+	}},
+	// adguard: hosts syntax, for AdGuard Home custom filtering rules and the Pi-hole custom.list.
+	"adguard": {ext: ".adguard.txt", render: renderHostsLines},
+	"pihole":  {ext: ".pihole.list", render: renderHostsLines},
+	// clash: the hosts section of a Clash config, a list where a domain has several IPs.
+	"clash": {ext: ".clash.yaml", render: func(input exportInput) string {
+		var builder strings.Builder
+		builder.WriteString("hosts:\n")
+		for _, domain := range input.domains() {
+			ips := input.ips(domain)
+			if len(ips) == 1 {
+				fmt.Fprintf(&builder, "  '%s': '%s'\n", domain, ips[0])
+			} else {
+				fmt.Fprintf(&builder, "  '%s': ['%s']\n", domain, strings.Join(ips, "', '"))
+			}
+		}
+		return builder.String()
+	}},
+	// singbox: a hosts DNS server with a rule sending the domains to it, to merge into the
+	// config of sing-box 1.12 or later with another -c.
+	"singbox": {ext: ".singbox.json", noHeader: true, render: func(input exportInput) string {
+		predefined := make(map[string][]string)
+		for _, domain := range input.domains() {
+			predefined[domain] = input.ips(domain)
+		}
+		config := map[string]any{"dns": map[string]any{
+			"servers": []any{map[string]any{"type": "hosts", "tag": "ip-scanner", "predefined": predefined}},
+			"rules":   []any{map[string]any{"domain": input.domains(), "server": "ip-scanner"}},
+		}}
+		data, _ := json.MarshalIndent(config, "", "  ")
+		return string(data) + "\n"
+	}},
+	// endpoints: the IPs found, fastest first, as ip:port for the clients that take a
+	// preferred CDN IP, with their latencies.
+	"endpoints": {ext: ".endpoints.txt", render: func(input exportInput) string {
+		var builder strings.Builder
+		seen := make(map[string]bool)
+		for _, record := range input.records {
+			addr := record.Addr()
+			if seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			endpoint := net.JoinHostPort(addr, strconv.Itoa(int(input.port)))
+			fmt.Fprintf(&builder, "%s # HttpRTT %.fms PingRTT %.fms\n", endpoint, record.HttpRTT, record.PingRTT)
+		}
+		return builder.String()
+	}},
+}
+
+func renderHostsLines(input exportInput) string {
+	var builder strings.Builder
+	for _, entry := range input.entries {
+		fmt.Fprintf(&builder, "%s %s\n", entry.IP, entry.Domain)
+	}
+	return builder.String()
+}
+
+// domains returns the domains of the entries in order, once each.
+func (input exportInput) domains() []string {
+	var domains []string
+	seen := make(map[string]bool)
+	for _, entry := range input.entries {
+		if !seen[entry.Domain] {
+			seen[entry.Domain] = true
+			domains = append(domains, entry.Domain)
+		}
+	}
+	return domains
+}
+
+// ips returns the IPs of domain in the entries, fastest first.
+func (input exportInput) ips(domain string) []string {
+	var ips []string
+	for _, entry := range input.entries {
+		if entry.Domain == domain {
+			ips = append(ips, entry.IP)
+		}
+	}
+	return ips
+}
+
+// ExportFormats returns the names of the export formats.
+func ExportFormats() []string {
+	formats := make([]string, 0, len(exporters))
+	for format := range exporters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// RenderExport renders the records of the last scan of site, fastest first, and the hosts
+// entries of its domains in format, with a comment saying where they come from.
+func RenderExport(format string, site SiteConfig, scanRecords ScanRecordArray, entries []HostsEntry) (string, error) {
+	exp, ok := exporters[format]
+	if !ok {
+		return "", fmt.Errorf("unknown export format %q, available: %s", format, strings.Join(ExportFormats(), ", "))
+	}
+	content := exp.render(exportInput{records: scanRecords, entries: entries, port: site.HTTP.Port})
+	if exp.noHeader {
+		return content, nil
+	}
+	header := fmt.Sprintf("# Generated by ip_scanner for %s at %s\n", site.Name, time.Now().Format(time.RFC3339))
+	return header + content, nil
+}
+
+// ExportFile is the file that format is exported to for site, next to its IPOutputFile.
+func ExportFile(site Site, format string) string {
+	return site.IPOutputFile + exporters[format].ext
+}
+
+// writeExports writes the records and entries in every format of General.Exports. It
+// reports whether a file was written, so the resolver needs to be reloaded.
+func writeExports(scanRecords ScanRecordArray, entries []HostsEntry, config *Config) bool {
+	site := RetrieveSiteCfg(config)
+	written := false
+	for _, format := range config.General.Exports {
+		content, err := RenderExport(format, site, scanRecords, entries)
+		if err != nil {
+			slog.Error("export failed", "error", err)
+			continue
+		}
+		file := ExportFile(site.Site, format)
+		if err = WriteFileAtomic(file, []byte(content), 0644); err != nil {
+			slog.Error("export failed", "format", format, "error", err)
+			continue
+		}
+		slog.Info("Exported:", "Format", format, "File", file, "Entries", len(entries))
+		written = true
+	}
+	return written
+}
+
+// RunReloadCmd runs command with the shell, such as "systemctl reload dnsmasq", so a
+// resolver picks up the exported files.
+func RunReloadCmd(command string) error {
+	if command == "" {
+		return nil
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("reload command %q failed: %w", command, err)
+	}
+	slog.Info("Reload command done:", "Command", command)
+	return nil
+}
@@ -0,0 +1,117 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FailureHistory counts, per IP, the consecutive scans in which the IP was probed and failed,
+// and the scans since it was last probed. It is stored next to the site's IPOutputFile, one
+// "ip failures skipped" line per IP.
+type FailureHistory map[string]FailureCount
+
+// FailureCount is the history of an IP.
+type FailureCount struct {
+	// Failures counts the consecutive scans in which the IP failed.
+	Failures int
+	// Skipped counts the scans since the IP was last probed, as it was excluded or the scan
+	// stopped at a limit before it.
+	Skipped int
+}
+
+func failureHistoryFile(site Site) string {
+	return site.IPOutputFile + ".failures"
+}
+
+// LoadFailureHistory reads the failure history of site. A missing file is an empty history.
+func LoadFailureHistory(site Site) (FailureHistory, error) {
+	history := make(FailureHistory)
+	f, err := os.Open(failureHistoryFile(site))
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 && len(fields) != 3 {
+			continue
+		}
+		var count FailureCount
+		if count.Failures, err = strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		if len(fields) == 3 {
+			if count.Skipped, err = strconv.Atoi(fields[2]); err != nil {
+				continue
+			}
+		}
+		history[fields[0]] = count
+	}
+	return history, scanner.Err()
+}
+
+// Failed returns the IPs that failed in at least the last n scans, as single address prefixes.
+func (history FailureHistory) Failed(n int) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for ip, count := range history {
+		if count.Failures < n {
+			continue
+		}
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	return prefixes
+}
+
+// Update counts one more failure for the failed IPs and forgets the IPs that worked. The
+// IPs that were not probed, mostly because they were excluded, are forgotten after n scans,
+// so they are probed again rather than excluded for good.
+func (history FailureHistory) Update(failed []string, found []string, n int) {
+	probed := make(map[string]bool, len(failed)+len(found))
+	for _, ip := range failed {
+		probed[ip] = true
+		count := history[ip]
+		count.Failures++
+		count.Skipped = 0
+		history[ip] = count
+	}
+	for _, ip := range found {
+		probed[ip] = true
+		delete(history, ip)
+	}
+	for ip, count := range history {
+		if probed[ip] {
+			continue
+		}
+		count.Skipped++
+		if count.Skipped >= n {
+			delete(history, ip)
+		} else {
+			history[ip] = count
+		}
+	}
+}
+
+// Save replaces the failure history file of site.
+func (history FailureHistory) Save(site Site) error {
+	ips := make([]string, 0, len(history))
+	for ip := range history {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	var builder strings.Builder
+	for _, ip := range ips {
+		builder.WriteString(fmt.Sprintf("%s %d %d\n", ip, history[ip].Failures, history[ip].Skipped))
+	}
+	return WriteFileAtomic(failureHistoryFile(site), []byte(builder.String()), 0644)
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFailureHistoryUpdate(t *testing.T) {
+	const n = 2
+	history := make(FailureHistory)
+	// 192.0.2.1 keeps failing, 192.0.2.2 fails then works.
+	scans := []struct {
+		failed []string
+		found  []string
+		want   FailureHistory
+	}{
+		{[]string{"192.0.2.1", "192.0.2.2"}, nil,
+			FailureHistory{"192.0.2.1": {Failures: 1}, "192.0.2.2": {Failures: 1}}},
+		{[]string{"192.0.2.1"}, []string{"192.0.2.2"},
+			FailureHistory{"192.0.2.1": {Failures: 2}}},
+		// 192.0.2.1 failed the last 2 scans, so it is excluded and not probed.
+		{nil, nil, FailureHistory{"192.0.2.1": {Failures: 2, Skipped: 1}}},
+		// After being skipped for 2 scans, it is forgotten and probed again.
+		{nil, nil, FailureHistory{}},
+		{[]string{"192.0.2.1"}, nil, FailureHistory{"192.0.2.1": {Failures: 1}}},
+	}
+	for i, scan := range scans {
+		failed := history.Failed(n)
+		history.Update(scan.failed, scan.found, n)
+		if !reflect.DeepEqual(history, scan.want) {
+			t.Fatalf("scan %d: history = %v, want %v", i, history, scan.want)
+		}
+		for _, prefix := range failed {
+			for _, ip := range scan.failed {
+				if prefix.Addr().String() == ip {
+					t.Errorf("scan %d: excluded IP %s was probed", i, ip)
+				}
+			}
+		}
+	}
+}
+
+func TestFailureHistorySaveLoad(t *testing.T) {
+	site := Site{IPOutputFile: filepath.Join(t.TempDir(), "ip.txt")}
+	history := FailureHistory{"192.0.2.1": {Failures: 3, Skipped: 1}, "2001:db8::1": {Failures: 1}}
+	if err := history.Save(site); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFailureHistory(site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, history) {
+		t.Errorf("LoadFailureHistory() = %v, want %v", loaded, history)
+	}
+	if got := loaded.Failed(3); len(got) != 1 || got[0].String() != "192.0.2.1/32" {
+		t.Errorf("Failed(3) = %v, want [192.0.2.1/32]", got)
+	}
+}
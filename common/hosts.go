@@ -0,0 +1,292 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The entries of a site are kept between these markers, so they are replaced on every
+// update and the rest of the hosts file is left alone:
+//
+//	# BEGIN ip-scanner:GoogleTranslate
+//	142.250.4.90	translate.googleapis.com
+//	# END ip-scanner:GoogleTranslate
+const (
+	hostsBeginMarker = "# BEGIN ip-scanner:"
+	hostsEndMarker   = "# END"
+)
+
+// maxHostsBackups is the number of backups of the hosts file that are kept.
+const maxHostsBackups = 10
+
+// HostsFile returns the path of the hosts file of the operating system.
+func HostsFile() (string, error) {
+	switch runtime.GOOS {
+	case "windows":
+		return "C:\\Windows\\System32\\drivers\\etc\\hosts", nil
+	case "darwin":
+		return "/private/etc/hosts", nil
+	case "linux":
+		return "/etc/hosts", nil
+	}
+	return "", fmt.Errorf("your operating system %s is unknown, please configure hosts yourself", runtime.GOOS)
+}
+
+// HostsPath returns General.HostsFile, or the hosts file of the operating system.
+func HostsPath(config *Config) (string, error) {
+	if config.General.HostsFile != "" {
+		return config.General.HostsFile, nil
+	}
+	return HostsFile()
+}
+
+// WriteToHosts writes the entries to the managed block of the site. Other entries of
+// exactly these domains are removed, since they would shadow the block. The hosts file is
+// backed up first, and left untouched if nothing changes.
+func WriteToHosts(hostsFile string, site string, entries []HostsEntry) error {
+	if len(entries) == 0 {
+		return errors.New("no hosts entries to write")
+	}
+	lines := make([]string, 0, len(entries))
+	var domains []string
+	for _, entry := range entries {
+		lines = append(lines, fmt.Sprintf("%s\t%s", entry.IP, entry.Domain))
+		if !containsFold(domains, entry.Domain) {
+			domains = append(domains, entry.Domain)
+		}
+	}
+	changed, err := updateHosts(hostsFile, site, lines, domains)
+	if err != nil {
+		return err
+	}
+	if changed {
+		slog.Info("Successfully written to hosts file", "file", hostsFile, "site", site, "entries", len(entries))
+	}
+	return nil
+}
+
+// HostsEntriesFor points every domain to ip, which may carry the port of a tcp or udp ping.
+func HostsEntriesFor(ip string, domains []string) []HostsEntry {
+	ip = (&ScanRecord{IP: ip}).Addr()
+	entries := make([]HostsEntry, 0, len(domains))
+	for _, domain := range domains {
+		entries = append(entries, HostsEntry{IP: ip, Domain: domain})
+	}
+	return entries
+}
+
+// RemoveFromHosts deletes the managed block of the site.
+func RemoveFromHosts(hostsFile string, site string) error {
+	changed, err := updateHosts(hostsFile, site, nil, nil)
+	if err != nil {
+		return err
+	}
+	if changed {
+		slog.Info("Successfully removed from hosts file", "file", hostsFile, "site", site)
+	}
+	return nil
+}
+
+// updateHosts replaces the block of the site and reports whether the hosts file changed.
+func updateHosts(hostsFile string, site string, lines []string, domains []string) (bool, error) {
+	info, err := os.Stat(hostsFile)
+	if err != nil {
+		return false, err
+	}
+	data, err := os.ReadFile(hostsFile)
+	if err != nil {
+		return false, err
+	}
+	content, err := replaceHostsBlock(string(data), site, lines, domains)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", hostsFile, err)
+	}
+	if content == string(data) {
+		slog.Info("The hosts file is up to date", "file", hostsFile, "site", site)
+		return false, nil
+	}
+	backup, err := backupHosts(hostsFile, data, info.Mode().Perm())
+	if err != nil {
+		return false, fmt.Errorf("backup hosts failed: %w", err)
+	}
+	slog.Info("Backed up the hosts file", "backup", backup)
+	return true, writeHosts(hostsFile, []byte(content), info.Mode().Perm())
+}
+
+// writeHosts replaces the hosts file atomically. The hosts file of a container is often
+// a bind mount that cannot be renamed over, so then it is written in place.
+func writeHosts(hostsFile string, data []byte, perm os.FileMode) error {
+	err := WriteFileAtomic(hostsFile, data, perm)
+	if err == nil {
+		return nil
+	}
+	slog.Warn("Could not replace the hosts file atomically, write it in place", "error", err)
+	return os.WriteFile(hostsFile, data, perm)
+}
+
+// replaceHostsBlock returns content with the block of the site replaced by lines, at the
+// same place, or appended if there was no block. Without lines the block is removed.
+// Outside the block, the hostnames equal to one of domains are dropped from their lines.
+// A block without its end marker is an error, rather than dropping the rest of the file.
+func replaceHostsBlock(content string, site string, lines []string, domains []string) (string, error) {
+	newline := "\n"
+	if strings.Contains(content, "\r\n") || content == "" && runtime.GOOS == "windows" {
+		newline = "\r\n"
+	}
+	content = strings.TrimSuffix(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	var old []string
+	if content != "" {
+		old = strings.Split(content, "\n")
+	}
+	begin := hostsBeginMarker + site
+	var result []string
+	blockAt := -1
+	inBlock := false
+	beginLine := 0
+	for i, line := range old {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case inBlock:
+			if strings.HasPrefix(trimmed, hostsEndMarker) {
+				inBlock = false
+			}
+			continue
+		case trimmed == begin:
+			inBlock = true
+			beginLine = i + 1
+			if blockAt < 0 {
+				blockAt = len(result)
+			}
+			continue
+		}
+		if line, ok := dropHostnames(line, domains); ok {
+			result = append(result, line)
+		}
+	}
+	if inBlock {
+		return "", fmt.Errorf("line %d: %s has no %s line after it", beginLine, begin, hostsEndMarker)
+	}
+	if len(lines) == 0 && blockAt == len(result) {
+		// The block was the end of the file, drop the blank lines that separated it.
+		for len(result) > 0 && strings.TrimSpace(result[len(result)-1]) == "" {
+			result = result[:len(result)-1]
+		}
+	}
+	if len(lines) > 0 {
+		block := append([]string{begin}, lines...)
+		block = append(block, hostsEndMarker+" ip-scanner:"+site)
+		if blockAt < 0 {
+			if len(result) > 0 && strings.TrimSpace(result[len(result)-1]) != "" {
+				result = append(result, "")
+			}
+			blockAt = len(result)
+		}
+		result = append(result[:blockAt], append(block, result[blockAt:]...)...)
+	}
+	if len(result) == 0 {
+		return "", nil
+	}
+	return strings.Join(result, newline) + newline, nil
+}
+
+// dropHostnames removes the hostnames equal to one of domains from a hosts line. It
+// returns false if no hostname is left, so the line should be dropped.
+func dropHostnames(line string, domains []string) (string, bool) {
+	entry, comment, _ := strings.Cut(line, "#")
+	fields := strings.Fields(entry)
+	if len(fields) < 2 {
+		return line, true
+	}
+	hostnames := fields[1:]
+	var kept []string
+	for _, hostname := range hostnames {
+		if !containsFold(domains, hostname) {
+			kept = append(kept, hostname)
+		}
+	}
+	switch {
+	case len(kept) == len(hostnames):
+		return line, true
+	case len(kept) == 0:
+		return "", false
+	}
+	line = fields[0] + "\t" + strings.Join(kept, " ")
+	if comment != "" {
+		line += " #" + comment
+	}
+	return line, true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// backupHosts saves data, the current hosts file, to a timestamped file next to it and
+// deletes the oldest backups beyond maxHostsBackups.
+func backupHosts(hostsFile string, data []byte, perm os.FileMode) (string, error) {
+	backup := hostsFile + ".ip-scanner-" + time.Now().Format("20060102-150405.000") + ".bak"
+	if err := os.WriteFile(backup, data, perm); err != nil {
+		return "", err
+	}
+	backups, err := HostsBackups(hostsFile)
+	if err != nil {
+		return backup, err
+	}
+	for len(backups) > maxHostsBackups {
+		if err = os.Remove(backups[0]); err != nil {
+			return backup, err
+		}
+		backups = backups[1:]
+	}
+	return backup, nil
+}
+
+// HostsBackups returns the backups of the hosts file, the oldest first.
+func HostsBackups(hostsFile string) ([]string, error) {
+	backups, err := filepath.Glob(hostsFile + ".ip-scanner-*.bak")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// RestoreHosts puts back a backup of the hosts file, the latest one if backup is empty,
+// and returns the backup restored.
+func RestoreHosts(hostsFile string, backup string) (string, error) {
+	if backup == "" {
+		backups, err := HostsBackups(hostsFile)
+		if err != nil {
+			return "", err
+		}
+		if len(backups) == 0 {
+			return "", errors.New("no backup of the hosts file found")
+		}
+		backup = backups[len(backups)-1]
+	}
+	data, err := os.ReadFile(backup)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(hostsFile)
+	if err != nil {
+		return "", err
+	}
+	if err = writeHosts(hostsFile, data, info.Mode().Perm()); err != nil {
+		return "", err
+	}
+	slog.Info("Successfully restored the hosts file", "backup", backup)
+	return backup, nil
+}
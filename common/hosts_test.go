@@ -0,0 +1,160 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteToHosts(t *testing.T) {
+	entries := []HostsEntry{
+		{IP: "192.0.2.1", Domain: "translate.google.com"},
+		{IP: "192.0.2.1", Domain: "translate.googleapis.com"},
+	}
+	block := "# BEGIN ip-scanner:Test\n192.0.2.1\ttranslate.google.com\n192.0.2.1\ttranslate.googleapis.com\n# END ip-scanner:Test\n"
+	tests := []struct {
+		name    string
+		hosts   string
+		entries []HostsEntry // nil removes the block
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "append",
+			hosts:   "127.0.0.1\tlocalhost\n",
+			entries: entries,
+			want:    "127.0.0.1\tlocalhost\n\n" + block,
+		},
+		{
+			name:    "replace in place",
+			hosts:   "127.0.0.1\tlocalhost\n# BEGIN ip-scanner:Test\n192.0.2.9\ttranslate.google.com\n# END ip-scanner:Test\n10.0.0.1\timportant.internal\n",
+			entries: entries,
+			want:    "127.0.0.1\tlocalhost\n" + block + "10.0.0.1\timportant.internal\n",
+		},
+		{
+			name:    "exact hostnames",
+			hosts:   "198.51.100.1\ttranslate.google.com mytranslate.google.com.local # old\n198.51.100.2 translate.googleapis.com\n",
+			entries: entries,
+			want:    "198.51.100.1\tmytranslate.google.com.local # old\n\n" + block,
+		},
+		{
+			name:  "remove",
+			hosts: "127.0.0.1\tlocalhost\n\n" + block,
+			want:  "127.0.0.1\tlocalhost\n",
+		},
+		{
+			name:  "remove keeps other sites",
+			hosts: "# BEGIN ip-scanner:Other\n192.0.2.7\tother.example\n# END ip-scanner:Other\n" + block + "192.168.1.1\tnas\n",
+			want:  "# BEGIN ip-scanner:Other\n192.0.2.7\tother.example\n# END ip-scanner:Other\n192.168.1.1\tnas\n",
+		},
+		{
+			name:    "crlf",
+			hosts:   "127.0.0.1\tlocalhost\r\n",
+			entries: entries,
+			want:    strings.ReplaceAll("127.0.0.1\tlocalhost\n\n"+block, "\n", "\r\n"),
+		},
+		{
+			name:    "unterminated block",
+			hosts:   "# BEGIN ip-scanner:Test\n192.0.2.9\ttranslate.google.com\n10.0.0.1\timportant.internal\n192.168.1.1\tnas\n",
+			entries: entries,
+			wantErr: "line 1: # BEGIN ip-scanner:Test has no # END line",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostsFile := filepath.Join(t.TempDir(), "hosts")
+			if err := os.WriteFile(hostsFile, []byte(tt.hosts), 0644); err != nil {
+				t.Fatal(err)
+			}
+			update := func() error {
+				if tt.entries == nil {
+					return RemoveFromHosts(hostsFile, "Test")
+				}
+				return WriteToHosts(hostsFile, "Test", tt.entries)
+			}
+			err := update()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				if got := readHosts(t, hostsFile); got != tt.hosts {
+					t.Errorf("hosts file changed after the error:\n%q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readHosts(t, hostsFile); got != tt.want {
+				t.Errorf("hosts file =\n%q\nwant\n%q", got, tt.want)
+			}
+			backups, _ := HostsBackups(hostsFile)
+			// A second update changes nothing and makes no backup.
+			if err = update(); err != nil {
+				t.Fatal(err)
+			}
+			if got := readHosts(t, hostsFile); got != tt.want {
+				t.Errorf("hosts file after the second update =\n%q\nwant\n%q", got, tt.want)
+			}
+			if again, _ := HostsBackups(hostsFile); len(again) != len(backups) {
+				t.Errorf("second update made %d backups, want none", len(again)-len(backups))
+			}
+		})
+	}
+}
+
+func TestHostsBackupsRestore(t *testing.T) {
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(hostsFile, []byte("127.0.0.1\tlocalhost\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Older backups, named like backupHosts does.
+	var old []string
+	for i := 0; i < maxHostsBackups; i++ {
+		backup := fmt.Sprintf("%s.ip-scanner-20200101-0000%02d.000.bak", hostsFile, i)
+		if err := os.WriteFile(backup, []byte("old\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		old = append(old, backup)
+	}
+	if err := WriteToHosts(hostsFile, "Test", []HostsEntry{{IP: "192.0.2.1", Domain: "a.example"}}); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := HostsBackups(hostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != maxHostsBackups {
+		t.Fatalf("%d backups, want %d", len(backups), maxHostsBackups)
+	}
+	if _, err = os.Stat(old[0]); !os.IsNotExist(err) {
+		t.Errorf("the oldest backup %s was not deleted", old[0])
+	}
+	restored, err := RestoreHosts(hostsFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != backups[len(backups)-1] {
+		t.Errorf("RestoreHosts() restored %s, want the latest %s", restored, backups[len(backups)-1])
+	}
+	if got := readHosts(t, hostsFile); got != "127.0.0.1\tlocalhost\n" {
+		t.Errorf("restored hosts file = %q, want the one before the update", got)
+	}
+	if _, err = RestoreHosts(hostsFile, old[1]); err != nil {
+		t.Fatal(err)
+	}
+	if got := readHosts(t, hostsFile); got != "old\n" {
+		t.Errorf("hosts file restored from %s = %q, want %q", old[1], got, "old\n")
+	}
+}
+
+func readHosts(t *testing.T, hostsFile string) string {
+	t.Helper()
+	data, err := os.ReadFile(hostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -26,12 +26,13 @@ func dialContext(destination string, destinationPort uint16) func(ctx context.Co
 	}
 }
 
-func reqHEAD(destination string, destinationPort uint16, config *Config) error {
+// reqHEAD requests url from destination, so the name in url is used for SNI and Host.
+func reqHEAD(destination string, site SiteConfig, url string) error {
 	slog.Debug("Https request using:", "IP", destination)
-	timeout := config.HTTP.Timeout
+	timeout := site.HTTP.Timeout
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: false},
-		DialContext:     dialContext(destination, destinationPort),
+		DialContext:     dialContext(destination, site.HTTP.Port),
 	}
 	client := http.Client{
 		Timeout:   timeout,
@@ -42,8 +43,6 @@ func reqHEAD(destination string, destinationPort uint16, config *Config) error {
 			return http.ErrUseLastResponse
 		},
 	}
-	siteCfg := RetrieveSiteCfg(config)
-	url := siteCfg.HttpsURL
 	req, err := http.NewRequest(http.MethodHead, url, nil)
 	if err != nil {
 		slog.Debug("http request:", slog.String("url", url), slog.Any("Error", err))
@@ -0,0 +1,196 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the probe latency histograms.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// metricFamily is a metric with its series, keyed by their labels as written, such as
+// {site="Cloudflare"}.
+type metricFamily struct {
+	help       string
+	kind       string // counter, gauge or histogram
+	values     map[string]float64
+	histograms map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// metrics is the registry of the telemetry of this process, in the Prometheus text format.
+var metrics = struct {
+	sync.Mutex
+	families map[string]*metricFamily
+}{families: map[string]*metricFamily{
+	"ip_scanner_ips_tested_total":          {help: "IPs tested, or skipped after a limit.", kind: "counter"},
+	"ip_scanner_ips_scanned_total":         {help: "IPs that passed the ping test.", kind: "counter"},
+	"ip_scanner_ips_found_total":           {help: "IPs that passed the ping and http tests.", kind: "counter"},
+	"ip_scanner_probes_total":              {help: "Ping and http probes by outcome.", kind: "counter"},
+	"ip_scanner_probe_latency_seconds":     {help: "Latency of the successful probes.", kind: "histogram"},
+	"ip_scanner_workers":                   {help: "Scan workers running.", kind: "gauge"},
+	"ip_scanner_workers_busy":              {help: "Scan workers testing an IP.", kind: "gauge"},
+	"ip_scanner_worker_busy_seconds_total": {help: "Time the scan workers spent testing IPs.", kind: "counter"},
+	"ip_scanner_fetches_total":             {help: "Fetches of the IP ranges by outcome.", kind: "counter"},
+	"ip_scanner_last_fetch_success_timestamp_seconds": {
+		help: "Unix time of the last successful fetch of the IP ranges.", kind: "gauge"},
+}}
+
+// labels formats pairs of label names and values, such as labels("site", "Cloudflare").
+func labels(pairs ...string) string {
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		fmt.Fprintf(&builder, "%s=\"%s\"", pairs[i], value)
+	}
+	builder.WriteByte('}')
+	return builder.String()
+}
+
+func addMetric(name string, series string, delta float64) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	family := metrics.families[name]
+	if family.values == nil {
+		family.values = make(map[string]float64)
+	}
+	family.values[series] += delta
+}
+
+func setMetric(name string, series string, value float64) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	family := metrics.families[name]
+	if family.values == nil {
+		family.values = make(map[string]float64)
+	}
+	family.values[series] = value
+}
+
+func observeMetric(name string, series string, value float64) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	family := metrics.families[name]
+	if family.histograms == nil {
+		family.histograms = make(map[string]*histogram)
+	}
+	h := family.histograms[series]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		family.histograms[series] = h
+	}
+	for i, bound := range latencyBuckets {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// observeProbe counts a ping or http probe of site, and its latency if it succeeded.
+func observeProbe(site string, probe string, protocol string, success bool, latency time.Duration) {
+	outcome := "failure"
+	if success {
+		outcome = "success"
+		observeMetric("ip_scanner_probe_latency_seconds",
+			labels("site", site, "probe", probe, "protocol", protocol), latency.Seconds())
+	}
+	addMetric("ip_scanner_probes_total",
+		labels("site", site, "probe", probe, "protocol", protocol, "outcome", outcome), 1)
+}
+
+// RecordFetch counts a fetch of the IP ranges of site, failed if err is not nil.
+func RecordFetch(site string, err error) {
+	if err != nil {
+		addMetric("ip_scanner_fetches_total", labels("site", site, "outcome", "failure"), 1)
+		return
+	}
+	addMetric("ip_scanner_fetches_total", labels("site", site, "outcome", "success"), 1)
+	setMetric("ip_scanner_last_fetch_success_timestamp_seconds", labels("site", site), float64(time.Now().Unix()))
+}
+
+// WriteMetrics writes the metrics in the Prometheus text exposition format.
+func WriteMetrics(w io.Writer) error {
+	metrics.Lock()
+	defer metrics.Unlock()
+	names := make([]string, 0, len(metrics.families))
+	for name := range metrics.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var builder strings.Builder
+	for _, name := range names {
+		family := metrics.families[name]
+		fmt.Fprintf(&builder, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.kind)
+		for _, series := range sortedKeys(family.values) {
+			fmt.Fprintf(&builder, "%s%s %s\n", name, series, formatFloat(family.values[series]))
+		}
+		for _, series := range sortedKeys(family.histograms) {
+			h := family.histograms[series]
+			var cumulative uint64
+			for i, bound := range latencyBuckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(&builder, "%s_bucket%s %d\n", name, withLabel(series, "le", formatFloat(bound)), cumulative)
+			}
+			fmt.Fprintf(&builder, "%s_bucket%s %d\n", name, withLabel(series, "le", "+Inf"), h.count)
+			fmt.Fprintf(&builder, "%s_sum%s %s\n", name, series, formatFloat(h.sum))
+			fmt.Fprintf(&builder, "%s_count%s %d\n", name, series, h.count)
+		}
+	}
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+// MetricsHandler serves the metrics to Prometheus.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteMetrics(w)
+}
+
+// WriteMetricsFile writes the metrics to path, for the textfile collector of the node
+// exporter after a scan or a fetch.
+func WriteMetricsFile(path string) error {
+	var builder strings.Builder
+	if err := WriteMetrics(&builder); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, []byte(builder.String()), 0644)
+}
+
+func withLabel(series string, name string, value string) string {
+	label := labels(name, value)
+	if series == "{}" || series == "" {
+		return label
+	}
+	return strings.TrimSuffix(series, "}") + "," + strings.TrimPrefix(label, "{")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -8,6 +8,7 @@ import (
 	"golang.org/x/net/ipv6"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -97,13 +98,7 @@ func pingIcmp(destination string, timeout time.Duration) (err error) {
 // an error if the attempt failed
 func pingTcp(destination string, destinationPort uint16, timeout time.Duration) (err error) {
 	conn, err := net.DialTimeout("tcp",
-		fmt.Sprintf("%s:%d", destination, int(destinationPort)), timeout)
-	defer func(conn net.Conn) {
-		err := conn.Close()
-		if err != nil {
-
-		}
-	}(conn)
+		net.JoinHostPort(destination, strconv.Itoa(int(destinationPort))), timeout)
 	if err != nil {
 		// If connection timed out, we return ErrorTimeout
 		if e := err.(*net.OpError).Timeout(); e {
@@ -114,6 +109,12 @@ func pingTcp(destination string, destinationPort uint16, timeout time.Duration)
 		}
 		return fmt.Errorf("dial Error: %v", err)
 	}
+	defer func(conn net.Conn) {
+		err := conn.Close()
+		if err != nil {
+
+		}
+	}(conn)
 	return
 }
 
@@ -123,7 +124,7 @@ func pingTcp(destination string, destinationPort uint16, timeout time.Duration)
 // response (connection timeout) as an open port.
 func pingUdp(destination string, destinationPort uint16, timeout time.Duration) (err error) {
 	c, err := net.Dial("udp",
-		fmt.Sprintf("%s:%d", destination, int(destinationPort)))
+		net.JoinHostPort(destination, strconv.Itoa(int(destinationPort))))
 	if err != nil {
 		return fmt.Errorf("dial error: %v", err)
 	}
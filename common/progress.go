@@ -0,0 +1,152 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressInterval is how often the progress of a scan is redrawn.
+const progressInterval = 500 * time.Millisecond
+
+// progressUI draws the progress of the scan at the bottom of the terminal: the IPs tested,
+// the rate, the ETA and the IPs found of every site, and the fastest IPs so far. The log
+// lines are written above it.
+type progressUI struct {
+	out        io.Writer
+	logOutput  io.Writer
+	foundLimit int
+	mutex      sync.Mutex
+	// lines is the height of the last drawing, erased before the next one.
+	lines int
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// startProgress starts drawing the progress on stderr if it is a terminal. Otherwise, or
+// with Debug logs, it returns nil and the progress is only logged.
+func startProgress(config *Config) *progressUI {
+	if config.General.Debug || !isTerminal(os.Stderr) {
+		return nil
+	}
+	ui := &progressUI{
+		out:        os.Stderr,
+		logOutput:  log.Writer(),
+		foundLimit: config.General.FoundLimit,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	log.SetOutput(ui)
+	go ui.run()
+	return ui
+}
+
+func (ui *progressUI) run() {
+	defer close(ui.done)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ui.stop:
+			return
+		case <-ticker.C:
+			ui.mutex.Lock()
+			ui.erase()
+			ui.draw()
+			ui.mutex.Unlock()
+		}
+	}
+}
+
+// Stop erases the progress and gives the terminal back to the logs. A nil ui does nothing.
+func (ui *progressUI) Stop() {
+	if ui == nil {
+		return
+	}
+	close(ui.stop)
+	<-ui.done
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+	ui.erase()
+	log.SetOutput(ui.logOutput)
+}
+
+// Write writes a log line above the progress.
+func (ui *progressUI) Write(p []byte) (int, error) {
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+	ui.erase()
+	n, err := ui.out.Write(p)
+	ui.draw()
+	return n, err
+}
+
+func (ui *progressUI) erase() {
+	if ui.lines > 0 {
+		fmt.Fprintf(ui.out, "\x1b[%dA\r\x1b[J", ui.lines)
+		ui.lines = 0
+	}
+}
+
+func (ui *progressUI) draw() {
+	status := ScanStatus()
+	if !status.Running || len(status.Sites) == 0 {
+		return
+	}
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%-16s %17s %10s %9s %9s\n", "Site", "Tested", "Rate", "ETA", "Found")
+	var top ScanRecordArray
+	sites := make(map[*ScanRecord]string)
+	for _, site := range status.Sites {
+		percent := 100
+		if site.Total > 0 {
+			percent = site.Tested * 100 / site.Total
+		}
+		found := fmt.Sprint(site.Found)
+		if ui.foundLimit > 0 {
+			found += fmt.Sprintf("/%d", ui.foundLimit)
+		}
+		eta := "-"
+		switch {
+		case site.Done:
+			eta = "done"
+		case site.ETA >= 1:
+			eta = time.Duration(site.ETA * float64(time.Second)).Round(time.Second).String()
+		case site.ETA > 0:
+			eta = "<1s"
+		}
+		fmt.Fprintf(&builder, "%-16s %17s %10s %9s %9s\n", truncate(site.Site, 16),
+			fmt.Sprintf("%d/%d %3d%%", site.Tested, site.Total, percent), fmt.Sprintf("%.1f/s", site.Rate), eta, found)
+		for _, record := range site.Top {
+			sites[record] = site.Site
+		}
+		top = append(top, site.Top...)
+	}
+	if len(top) > 0 {
+		sort.SliceStable(top, func(i, j int) bool {
+			return top[i].HttpRTT < top[j].HttpRTT
+		})
+		if len(top) > topRecords {
+			top = top[:topRecords]
+		}
+		fmt.Fprintf(&builder, "\n%-16s %-41s %8s %8s\n", "Fastest", "IP", "HttpRTT", "PingRTT")
+		for _, record := range top {
+			fmt.Fprintf(&builder, "%-16s %-41s %8.f %8.f\n", truncate(sites[record], 16), truncate(record.IP, 41), record.HttpRTT, record.PingRTT)
+		}
+	}
+	drawing := builder.String()
+	ui.out.Write([]byte(drawing))
+	ui.lines = strings.Count(drawing, "\n")
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
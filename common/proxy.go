@@ -0,0 +1,205 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// proxyHelloTimeout bounds the wait for the TLS ClientHello of a client.
+	proxyHelloTimeout = 10 * time.Second
+	// proxyDialTimeout bounds a connection to an IP, before failing over to the next one.
+	proxyDialTimeout = 3 * time.Second
+	// maxProxyAttempts is the number of IPs tried for a connection.
+	maxProxyAttempts = 5
+	// proxyCooldown is how long an IP that failed a dial is tried after the others.
+	proxyCooldown = time.Minute
+)
+
+// ProxyRoute sends the connections to the domains of a site to its IPs, fastest first.
+type ProxyRoute struct {
+	Site    string
+	Domains []string
+	Port    uint16
+	IPs     []string
+}
+
+// SNIProxy forwards TLS connections to the best IPs of the site of their server name,
+// read from the ClientHello, without terminating TLS. When a dial fails, the connection
+// fails over to the next IP.
+type SNIProxy struct {
+	Listen string
+
+	mutex  sync.RWMutex
+	routes map[string]*ProxyRoute
+	failed map[string]time.Time
+}
+
+// SetRoutes replaces the routes of the proxy.
+func (proxy *SNIProxy) SetRoutes(routes []ProxyRoute) {
+	byName := make(map[string]*ProxyRoute)
+	for i := range routes {
+		for _, domain := range routes[i].Domains {
+			byName[strings.ToLower(domain)] = &routes[i]
+		}
+	}
+	proxy.mutex.Lock()
+	proxy.routes = byName
+	proxy.mutex.Unlock()
+}
+
+// ListenAndServe accepts connections on Listen until ctx is done.
+func (proxy *SNIProxy) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", proxy.Listen)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	slog.Info("SNI proxy listening:", "Address", proxy.Listen)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go proxy.handle(conn)
+	}
+}
+
+func (proxy *SNIProxy) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(proxyHelloTimeout))
+	serverName, hello, err := peekServerName(conn)
+	if err != nil {
+		slog.Debug("no tls client hello", "Client", conn.RemoteAddr(), "error", err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	proxy.mutex.RLock()
+	route, ok := proxy.routes[strings.ToLower(serverName)]
+	proxy.mutex.RUnlock()
+	if !ok {
+		slog.Warn("No site for the server name, connection closed:", "ServerName", serverName, "Client", conn.RemoteAddr())
+		return
+	}
+	upstream, err := proxy.dial(route)
+	if err != nil {
+		slog.Error("Proxy failed:", "Site", route.Site, "ServerName", serverName, "error", err)
+		return
+	}
+	defer upstream.Close()
+	if _, err = upstream.Write(hello); err != nil {
+		return
+	}
+	pipe(conn, upstream)
+}
+
+// dial connects to the IPs of the route in turn, those that failed recently last.
+func (proxy *SNIProxy) dial(route *ProxyRoute) (net.Conn, error) {
+	var errs []error
+	for _, ip := range proxy.candidates(route) {
+		if len(errs) == maxProxyAttempts {
+			break
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), proxyDialTimeout)
+		conn, err := dialContext(ip, route.Port)(ctx, "tcp", "")
+		cancel()
+		if err == nil {
+			slog.Debug("proxy connected", "Site", route.Site, "IP", ip)
+			return conn, nil
+		}
+		slog.Warn("Proxy dial failed, trying the next IP:", "Site", route.Site, "IP", ip, "error", err)
+		proxy.mutex.Lock()
+		if proxy.failed == nil {
+			proxy.failed = make(map[string]time.Time)
+		}
+		proxy.failed[ip] = time.Now()
+		proxy.mutex.Unlock()
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no IP found by the last scan")
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (proxy *SNIProxy) candidates(route *ProxyRoute) []string {
+	proxy.mutex.RLock()
+	defer proxy.mutex.RUnlock()
+	var healthy, failed []string
+	for _, ip := range route.IPs {
+		if at, ok := proxy.failed[ip]; ok && time.Since(at) < proxyCooldown {
+			failed = append(failed, ip)
+		} else {
+			healthy = append(healthy, ip)
+		}
+	}
+	return append(healthy, failed...)
+}
+
+// peekServerName reads the TLS ClientHello from conn and returns its server name, with
+// the bytes read so they can be replayed to the server.
+func peekServerName(conn net.Conn) (string, []byte, error) {
+	var buf bytes.Buffer
+	var serverName string
+	gotHello := false
+	err := tls.Server(readOnlyConn{Conn: conn, reader: io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName, gotHello = hello.ServerName, true
+			// Stop the handshake, the server will answer the client.
+			return nil, io.EOF
+		},
+	}).Handshake()
+	if !gotHello {
+		return "", nil, err
+	}
+	if serverName == "" {
+		return "", nil, errors.New("the client hello has no server name")
+	}
+	return serverName, buf.Bytes(), nil
+}
+
+// readOnlyConn lets crypto/tls read the ClientHello without answering it.
+type readOnlyConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (conn readOnlyConn) Read(p []byte) (int, error) {
+	return conn.reader.Read(p)
+}
+
+func (conn readOnlyConn) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// pipe copies between the connections in both directions until both are done.
+func pipe(client net.Conn, server net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyConn := func(dst net.Conn, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		if tcpConn, ok := dst.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	go copyConn(client, server)
+	go copyConn(server, client)
+	wg.Wait()
+}
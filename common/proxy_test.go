@@ -0,0 +1,179 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startProxy serves the proxy on a free local port and returns its address.
+func startProxy(t *testing.T, proxy *SNIProxy) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go proxy.handle(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// proxyClient makes https requests through the proxy at addr, whatever the url.
+func proxyClient(addr string) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+			// The stub server has a certificate of its own.
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+}
+
+func TestSNIProxyFailover(t *testing.T) {
+	serverNames := make(chan string, 4)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "stub upstream")
+	}))
+	upstream.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNames <- hello.ServerName
+			return nil, nil
+		},
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+	_, port, _ := net.SplitHostPort(upstream.Listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	// Nothing listens on 127.0.0.2 at the port of the stub, so the first IP refuses
+	// the connection and the proxy fails over to the stub on 127.0.0.1.
+	proxy := new(SNIProxy)
+	proxy.SetRoutes([]ProxyRoute{{
+		Site:    "Test",
+		Domains: []string{"test.example", "www.test.example"},
+		Port:    uint16(portNumber),
+		IPs:     []string{"127.0.0.2", "127.0.0.1"},
+	}})
+	client := proxyClient(startProxy(t, proxy))
+
+	resp, err := client.Get("https://Test.Example/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "stub upstream" {
+		t.Errorf("response = %q, want the stub upstream", body)
+	}
+	if got := <-serverNames; got != "Test.Example" {
+		t.Errorf("upstream got server name %q, want Test.Example", got)
+	}
+	proxy.mutex.RLock()
+	_, failed := proxy.failed["127.0.0.2"]
+	proxy.mutex.RUnlock()
+	if !failed {
+		t.Error("the refused IP is not remembered as failed")
+	}
+	// The failed IP is tried last from now on.
+	route := proxy.routes["test.example"]
+	if got, want := proxy.candidates(route), []string{"127.0.0.1", "127.0.0.2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("candidates() = %v, want %v", got, want)
+	}
+
+	// A server name of no site is not forwarded.
+	if resp, err = client.Get("https://other.example/"); err == nil {
+		resp.Body.Close()
+		t.Error("a connection to an unknown server name was forwarded")
+	}
+	select {
+	case name := <-serverNames:
+		t.Errorf("upstream got a connection for %q", name)
+	default:
+	}
+}
+
+func TestSNIProxyAllIPsFail(t *testing.T) {
+	// A port that refuses connections.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+	proxy := new(SNIProxy)
+	route := &ProxyRoute{Site: "Test", Port: port, IPs: []string{"127.0.0.1", "127.0.0.2"}}
+	if _, err = proxy.dial(route); err == nil {
+		t.Fatal("dial() succeeded without a listening IP")
+	}
+	if len(proxy.failed) != 2 {
+		t.Errorf("failed = %v, want both IPs", proxy.failed)
+	}
+	if _, err = proxy.dial(&ProxyRoute{Site: "Test", Port: port}); err == nil {
+		t.Error("dial() succeeded without IPs")
+	}
+}
+
+func TestPeekServerName(t *testing.T) {
+	tests := []struct {
+		name       string
+		serverName string
+		wantErr    bool
+	}{
+		{"server name", "test.example", false},
+		// Go sends no server name for an IP address.
+		{"no server name", "127.0.0.1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go tls.Client(client, &tls.Config{ServerName: tt.serverName, InsecureSkipVerify: true}).Handshake()
+			server.SetReadDeadline(time.Now().Add(5 * time.Second))
+			serverName, hello, err := peekServerName(server)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("peekServerName() = %q, want an error", serverName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if serverName != tt.serverName {
+				t.Errorf("peekServerName() = %q, want %q", serverName, tt.serverName)
+			}
+			// The bytes read are the whole ClientHello record, to replay to the server.
+			if len(hello) < 5 || hello[0] != 0x16 || len(hello) != 5+int(hello[3])<<8+int(hello[4]) {
+				t.Errorf("peekServerName() returned %d bytes that are not one handshake record", len(hello))
+			}
+		})
+	}
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		client.Write([]byte("GET / HTTP/1.1\r\nHost: test.example\r\n\r\n"))
+		client.Close()
+	}()
+	if _, _, err := peekServerName(server); err == nil {
+		t.Error("peekServerName() of a plain http request did not fail")
+	}
+}
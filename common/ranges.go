@@ -0,0 +1,169 @@
+package common
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth stops include cycles.
+const maxIncludeDepth = 8
+
+// RangeEntry is one line of an ip ranges file. The format is one entry per line:
+//
+//	# a comment, blank lines are ignored as well
+//	142.250.0.0/15             a prefix
+//	216.239.32.1               a single address
+//	8.8.4.0-8.8.8.255          an inclusive address range
+//	!142.250.64.0/24           an exclusion, subtracted from every other entry
+//	@include other.txt         the entries of another file, relative to this one
+//	74.125.0.0/16 weight=5 hk  weights and tags after the range
+//
+// Entries with a higher weight (default 1) are scanned first. Every other word after
+// the range is a tag, which is kept for tooling and the provenance of generated files.
+// A tag may not be an address or start with -, so a range with spaces is rejected.
+type RangeEntry struct {
+	Prefixes []netip.Prefix
+	Exclude  bool
+	Weight   int
+	Tags     []string
+	File     string
+	Line     int
+}
+
+// RangeGroup is a set of merged prefixes sharing the same weight.
+type RangeGroup struct {
+	Weight   int
+	Prefixes []netip.Prefix
+}
+
+// ParseRangesFile reads an ip ranges file and the files it includes. Every invalid
+// line is reported, with its file and line number, in the returned error.
+func ParseRangesFile(file string) ([]RangeEntry, error) {
+	return parseRangesFile(file, 0)
+}
+
+func parseRangesFile(file string, depth int) ([]RangeEntry, error) {
+	if depth > maxIncludeDepth {
+		return nil, fmt.Errorf("%s: includes nested more than %d levels deep", file, maxIncludeDepth)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []RangeEntry
+	var errs []error
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "@include" {
+			if len(fields) != 2 {
+				errs = append(errs, fmt.Errorf("%s:%d: @include needs exactly one file", file, lineNo))
+				continue
+			}
+			included := fields[1]
+			if !filepath.IsAbs(included) {
+				included = filepath.Join(filepath.Dir(file), included)
+			}
+			more, err := parseRangesFile(included, depth+1)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s:%d: %w", file, lineNo, err))
+				continue
+			}
+			entries = append(entries, more...)
+			continue
+		}
+		entry, err := parseRangeEntry(fields)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", file, lineNo, err))
+			continue
+		}
+		entry.File = file
+		entry.Line = lineNo
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return entries, errors.Join(errs...)
+}
+
+func parseRangeEntry(fields []string) (RangeEntry, error) {
+	entry := RangeEntry{Weight: 1}
+	text := fields[0]
+	if strings.HasPrefix(text, "!") {
+		entry.Exclude = true
+		text = text[1:]
+	}
+	prefixes, err := ParseRange(text)
+	if err != nil {
+		return entry, fmt.Errorf("invalid ip range %q: %w", fields[0], err)
+	}
+	entry.Prefixes = prefixes
+	for _, field := range fields[1:] {
+		if value, ok := strings.CutPrefix(field, "weight="); ok {
+			weight, err := strconv.Atoi(value)
+			if err != nil || weight < 0 {
+				return entry, fmt.Errorf("invalid weight %q", field)
+			}
+			entry.Weight = weight
+			continue
+		}
+		// A range written with spaces, such as 1.2.3.4 - 1.2.3.9, is not a range and tags.
+		if _, err := ParseRange(field); err == nil || strings.HasPrefix(field, "-") {
+			return entry, fmt.Errorf("unexpected %q after the ip range, write ranges without spaces, such as 1.2.3.4-1.2.3.9", field)
+		}
+		entry.Tags = append(entry.Tags, field)
+	}
+	return entry, nil
+}
+
+// NormalizeRanges merges and dedupes the entries, subtracts the exclusions and the extra
+// exclude prefixes, and groups what is left by weight, highest first. An address listed
+// with several weights is only kept in its highest one.
+func NormalizeRanges(entries []RangeEntry, exclude []netip.Prefix, withIPv6 bool) []RangeGroup {
+	byWeight := make(map[int][]netip.Prefix)
+	for _, entry := range entries {
+		if entry.Exclude {
+			exclude = append(exclude, entry.Prefixes...)
+			continue
+		}
+		for _, p := range entry.Prefixes {
+			if !withIPv6 && !p.Addr().Is4() {
+				continue
+			}
+			byWeight[entry.Weight] = append(byWeight[entry.Weight], p)
+		}
+	}
+	weights := make([]int, 0, len(byWeight))
+	for weight := range byWeight {
+		weights = append(weights, weight)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(weights)))
+	var groups []RangeGroup
+	for _, weight := range weights {
+		prefixes := SubtractPrefixes(byWeight[weight], exclude)
+		if len(prefixes) == 0 {
+			continue
+		}
+		groups = append(groups, RangeGroup{Weight: weight, Prefixes: prefixes})
+		exclude = append(exclude, prefixes...)
+	}
+	return groups
+}
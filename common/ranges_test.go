@@ -0,0 +1,162 @@
+package common
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeRangesFile(t *testing.T, file string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func prefixes(ss ...string) []netip.Prefix {
+	var result []netip.Prefix
+	for _, s := range ss {
+		result = append(result, netip.MustParsePrefix(s))
+	}
+	return result
+}
+
+func TestParseRangesFile(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.txt")
+	writeRangesFile(t, main, `# Google
+142.250.0.0/15    weight=5 hk edge # trailing comment
+
+216.239.32.1
+8.8.4.0-8.8.8.255
+!142.250.64.0/24
+@include sub/more.txt
+2001:db8::/32 weight=0
+`)
+	writeRangesFile(t, filepath.Join(dir, "sub", "more.txt"), "203.0.113.0/24 more\n")
+	entries, err := ParseRangesFile(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RangeEntry{
+		{Prefixes: prefixes("142.250.0.0/15"), Weight: 5, Tags: []string{"hk", "edge"}, File: main, Line: 2},
+		{Prefixes: prefixes("216.239.32.1/32"), Weight: 1, File: main, Line: 4},
+		{Prefixes: prefixes("8.8.4.0/22", "8.8.8.0/24"), Weight: 1, File: main, Line: 5},
+		{Prefixes: prefixes("142.250.64.0/24"), Exclude: true, Weight: 1, File: main, Line: 6},
+		{Prefixes: prefixes("203.0.113.0/24"), Weight: 1, Tags: []string{"more"}, File: filepath.Join(dir, "sub", "more.txt"), Line: 1},
+		{Prefixes: prefixes("2001:db8::/32"), Weight: 0, File: main, Line: 8},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("ParseRangesFile() =\n%+v\nwant\n%+v", entries, want)
+	}
+}
+
+func TestParseRangesFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"invalid range", "192.0.2.0/24\nnot-a-range\n", []string{":2: invalid ip range \"not-a-range\""}},
+		{"range with spaces", "1.2.3.4 - 1.2.3.9\n", []string{":1: unexpected \"-\" after the ip range"}},
+		{"range with a space", "1.2.3.4 -1.2.3.9\n", []string{":1: unexpected \"-1.2.3.9\""}},
+		{"two ranges", "192.0.2.0/24 198.51.100.0/24\n", []string{":1: unexpected \"198.51.100.0/24\""}},
+		{"reversed range", "10.0.0.9-10.0.0.1\n", []string{":1: invalid ip range"}},
+		{"mixed range", "10.0.0.1-2001:db8::1\n", []string{":1: invalid ip range"}},
+		{"invalid weight", "192.0.2.0/24 weight=-1\n", []string{":1: invalid weight \"weight=-1\""}},
+		{"include without file", "@include\n", []string{":1: @include needs exactly one file"}},
+		{"missing include", "@include missing.txt\n", []string{":1: open "}},
+		{"every error", "bad\n192.0.2.0/24\nworse weight=x\n", []string{":1: invalid ip range \"bad\"", ":3: invalid ip range \"worse\""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "ranges.txt")
+			writeRangesFile(t, file, tt.content)
+			_, err := ParseRangesFile(file)
+			if err == nil {
+				t.Fatal("ParseRangesFile() did not fail")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), file+want) {
+					t.Errorf("ParseRangesFile() error = %v, want %q", err, file+want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRangesFileIncludeDepth(t *testing.T) {
+	dir := t.TempDir()
+	// A file including itself is stopped at maxIncludeDepth.
+	self := filepath.Join(dir, "self.txt")
+	writeRangesFile(t, self, "192.0.2.0/24\n@include self.txt\n")
+	if _, err := ParseRangesFile(self); err == nil || !strings.Contains(err.Error(), "nested more than") {
+		t.Errorf("ParseRangesFile() error = %v, want the nesting limit", err)
+	}
+	// A chain of maxIncludeDepth includes is fine.
+	for i := 0; i < maxIncludeDepth; i++ {
+		writeRangesFile(t, filepath.Join(dir, fmt.Sprintf("chain%d.txt", i)), fmt.Sprintf("@include chain%d.txt\n", i+1))
+	}
+	writeRangesFile(t, filepath.Join(dir, fmt.Sprintf("chain%d.txt", maxIncludeDepth)), "192.0.2.0/24\n")
+	entries, err := ParseRangesFile(filepath.Join(dir, "chain0.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Prefixes[0] != netip.MustParsePrefix("192.0.2.0/24") {
+		t.Errorf("ParseRangesFile() = %+v, want 192.0.2.0/24 from the last file", entries)
+	}
+}
+
+func TestNormalizeRanges(t *testing.T) {
+	entries := []RangeEntry{
+		{Prefixes: prefixes("10.0.0.0/8"), Weight: 1},
+		{Prefixes: prefixes("10.1.0.0/16"), Weight: 5},
+		{Prefixes: prefixes("10.1.0.0/24"), Weight: 1},
+		{Prefixes: prefixes("10.2.0.0/16"), Exclude: true},
+		{Prefixes: prefixes("2001:db8::/32"), Weight: 1},
+		{Prefixes: prefixes("192.0.2.0/24"), Weight: 0},
+	}
+	tests := []struct {
+		name     string
+		exclude  []netip.Prefix
+		withIPv6 bool
+		want     []RangeGroup
+	}{
+		{
+			name: "ipv4",
+			want: []RangeGroup{
+				{Weight: 5, Prefixes: prefixes("10.1.0.0/16")},
+				{Weight: 1, Prefixes: prefixes("10.0.0.0/16", "10.3.0.0/16", "10.4.0.0/14", "10.8.0.0/13", "10.16.0.0/12", "10.32.0.0/11", "10.64.0.0/10", "10.128.0.0/9")},
+				{Weight: 0, Prefixes: prefixes("192.0.2.0/24")},
+			},
+		},
+		{
+			name:     "with ipv6 and extra exclusions",
+			exclude:  prefixes("10.0.0.0/9", "192.0.2.0/24"),
+			withIPv6: true,
+			want: []RangeGroup{
+				{Weight: 1, Prefixes: prefixes("10.128.0.0/9", "2001:db8::/32")},
+			},
+		},
+		{
+			name:     "everything excluded",
+			exclude:  prefixes("0.0.0.0/0", "::/0"),
+			withIPv6: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NormalizeRanges(entries, tt.exclude, tt.withIPv6)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NormalizeRanges() =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
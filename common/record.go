@@ -2,6 +2,7 @@ package common
 
 import (
 	"log/slog"
+	"net"
 	"sync"
 	"sync/atomic"
 )
@@ -16,11 +17,21 @@ type ScanRecord struct {
 type ScanRecordArray []*ScanRecord
 
 type ScanResult struct {
+	site        string
 	scanned     int32
 	scanRecords ScanRecordArray
+	failedIPs   []string
 	recordMutex sync.Mutex
 }
 
+// Addr returns the IP of the record without the port that tcp and udp pings add.
+func (record *ScanRecord) Addr() string {
+	if host, _, err := net.SplitHostPort(record.IP); err == nil {
+		return host
+	}
+	return record.IP
+}
+
 func (records *ScanRecordArray) Len() int {
 	return len(*records)
 }
@@ -36,9 +47,7 @@ func (records *ScanRecordArray) Swap(i, j int) {
 }
 
 func (result *ScanResult) Scanned() int {
-	result.recordMutex.Lock()
-	defer result.recordMutex.Unlock()
-	return int(result.scanned)
+	return int(atomic.LoadInt32(&result.scanned))
 }
 
 func (result *ScanResult) Found() int {
@@ -54,13 +63,22 @@ func (result *ScanResult) AddRecord(record *ScanRecord) {
 	}
 	result.scanRecords = append(result.scanRecords, record)
 	result.recordMutex.Unlock()
-	slog.Info("Found an IP:", slog.String("IP", record.IP), slog.Float64("PingRTT", record.PingRTT),
+	addMetric("ip_scanner_ips_found_total", labels("site", result.site), 1)
+	slog.Info("Found an IP:", slog.String("Site", result.site), slog.String("IP", record.IP), slog.Float64("PingRTT", record.PingRTT),
 		slog.Float64("HttpRTT", record.HttpRTT))
 }
 
+// AddFailure remembers an IP that failed the ping or http test.
+func (result *ScanResult) AddFailure(ip string) {
+	result.recordMutex.Lock()
+	result.failedIPs = append(result.failedIPs, ip)
+	result.recordMutex.Unlock()
+}
+
 func (result *ScanResult) IncScanCounter() {
-	atomic.AddInt32(&(result.scanned), 1)
-	if result.scanned%1000 == 0 {
-		slog.Info("Progress:", "Scanned", result.scanned)
+	scanned := atomic.AddInt32(&(result.scanned), 1)
+	addMetric("ip_scanner_ips_scanned_total", labels("site", result.site), 1)
+	if scanned%1000 == 0 {
+		slog.Info("Progress:", "Site", result.site, "Scanned", scanned)
 	}
 }
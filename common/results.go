@@ -0,0 +1,66 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+)
+
+// resultsFile keeps the records of the last scan, with their latencies, as JSON
+// next to the plain list of IPs in IPOutputFile.
+func resultsFile(site Site) string {
+	return site.IPOutputFile + ".json"
+}
+
+func saveResults(scanRecords ScanRecordArray, site Site) error {
+	if scanRecords == nil {
+		scanRecords = ScanRecordArray{}
+	}
+	data, err := json.MarshalIndent(scanRecords, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(resultsFile(site), append(data, '\n'), 0644)
+}
+
+// ResultsModTime returns when the results of site were last written, the zero time if
+// there are none.
+func ResultsModTime(site Site) time.Time {
+	var latest time.Time
+	for _, file := range []string{resultsFile(site), site.IPOutputFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// LoadResults returns the records of the last scan of site, fastest first. Without the
+// JSON results, the IPs of IPOutputFile are returned without latencies.
+func LoadResults(site Site) (ScanRecordArray, error) {
+	data, err := os.ReadFile(resultsFile(site))
+	if err == nil {
+		var scanRecords ScanRecordArray
+		err = json.Unmarshal(data, &scanRecords)
+		return scanRecords, err
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err := os.Open(site.IPOutputFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var scanRecords ScanRecordArray
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			scanRecords = append(scanRecords, &ScanRecord{IP: line})
+		}
+	}
+	return scanRecords, scanner.Err()
+}
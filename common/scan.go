@@ -1,38 +1,45 @@
 package common
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
+	"net"
 	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func pingOneIP(destination string, destinationPort uint16, config *Config, record *ScanRecord) bool {
+func pingOneIP(destination string, site SiteConfig, record *ScanRecord) bool {
+	destinationPort := site.Ping.Port
 	slog.Debug("Start Ping:", "IP", destination)
 	record.IP = destination
-	record.Protocol = config.Ping.Protocol
-	if config.Ping.Protocol == "udp" || config.Ping.Protocol == "tcp" {
-		record.IP += fmt.Sprintf(":%d", destinationPort)
+	record.Protocol = site.Ping.Protocol
+	if site.Ping.Protocol == "udp" || site.Ping.Protocol == "tcp" {
+		record.IP = net.JoinHostPort(destination, strconv.Itoa(int(destinationPort)))
 	}
 	successTimes := 0
 	var latencies []int64
-	for i := 0; i < config.Ping.Count; i += 1 {
+	for i := 0; i < site.Ping.Count; i += 1 {
 		var err error
 		// startTime for calculating the latency/RTT
 		startTime := time.Now()
 
-		switch config.Ping.Protocol {
+		switch site.Ping.Protocol {
 		case "icmp":
-			err = pingIcmp(destination, config.Ping.Timeout)
+			err = pingIcmp(destination, site.Ping.Timeout)
 		case "tcp":
-			err = pingTcp(destination, destinationPort, config.Ping.Timeout)
+			err = pingTcp(destination, destinationPort, site.Ping.Timeout)
 		case "udp":
-			err = pingUdp(destination, destinationPort, config.Ping.Timeout)
+			err = pingUdp(destination, destinationPort, site.Ping.Timeout)
 		}
 		//store the time elapsed before processing potential errors
-		latency := time.Since(startTime).Milliseconds()
+		elapsed := time.Since(startTime)
+		latency := elapsed.Milliseconds()
 
 		// evaluate potential ping failures
 		if err != nil {
@@ -45,19 +52,23 @@ func pingOneIP(destination string, destinationPort uint16, config *Config, recor
 				latency = 0
 			}
 		}
+		succeeded := false
 		switch latency {
 		case -1, 0:
 			// do nothing
 		case 9999999:
 			// For udp, a timeout indicates that the port *maybe* open.
-			if config.Ping.Protocol == "udp" {
+			if site.Ping.Protocol == "udp" {
 				successTimes += 1
 				latencies = append(latencies, latency)
+				succeeded = true
 			}
 		default:
 			successTimes += 1
 			latencies = append(latencies, latency)
+			succeeded = true
 		}
+		observeProbe(site.Name, "ping", site.Ping.Protocol, succeeded, elapsed)
 		// sleep 20 milliseconds between pings to prevent floods
 		time.Sleep(100 * time.Millisecond)
 	}
@@ -67,24 +78,26 @@ func pingOneIP(destination string, destinationPort uint16, config *Config, recor
 	}
 	record.PingRTT = math.Round(float64(sum) / float64(len(latencies)))
 	success := false
-	if (config.Ping.all && successTimes == config.Ping.Count) || (!config.Ping.all && successTimes > 0) {
+	if (site.Ping.All && successTimes == site.Ping.Count) || (!site.Ping.All && successTimes > 0) {
 		success = true
 	}
 	return success
 }
 
-func reqOneIP(destination string, destinationPort uint16, config *Config, record *ScanRecord) bool {
+func reqOneIP(destination string, site SiteConfig, url string, record *ScanRecord) bool {
 	slog.Debug("Start Ping:", "IP", destination)
 	successTimes := 0
 	var latencies []int64
-	for i := 0; i < config.HTTP.Count; i += 1 {
+	for i := 0; i < site.HTTP.Count; i += 1 {
 		var err error
 		// startTime for calculating the latency/RTT
 		startTime := time.Now()
 
-		err = reqHEAD(destination, destinationPort, config)
+		err = reqHEAD(destination, site, url)
 		//store the time elapsed before processing potential errors
-		latency := time.Since(startTime).Milliseconds()
+		elapsed := time.Since(startTime)
+		latency := elapsed.Milliseconds()
+		observeProbe(site.Name, "http", "https", err == nil, elapsed)
 
 		// evaluate potential ping failures
 		if err != nil {
@@ -102,57 +115,232 @@ func reqOneIP(destination string, destinationPort uint16, config *Config, record
 	}
 	record.HttpRTT = math.Round(float64(sum) / float64(len(latencies)))
 	success := false
-	if (config.HTTP.all && successTimes == config.HTTP.Count) || (!config.HTTP.all && successTimes > 0) {
+	if (site.HTTP.All && successTimes == site.HTTP.Count) || (!site.HTTP.All && successTimes > 0) {
 		success = true
 	}
 	return success
 }
 
-func testOne(ch chan string, config *Config, scanResult *ScanResult, wg *sync.WaitGroup) {
+// siteScan is the scan of one site in a run.
+type siteScan struct {
+	config *Config
+	site   SiteConfig
+	ips    []string
+	result *ScanResult
+	// tested counts the IPs done, or skipped after a limit.
+	tested int32
+}
+
+// limitReached reports whether the site has scanned or found as many IPs as configured.
+func (scan *siteScan) limitReached() bool {
+	general := scan.config.General
+	return (general.ScannedLimit > 0 && general.ScannedLimit < scan.result.Scanned()) ||
+		(general.FoundLimit > 0 && general.FoundLimit < scan.result.Found())
+}
+
+// addFailure remembers an IP that failed for the failure history, if SkipFailedScans is on.
+func (scan *siteScan) addFailure(ip string) {
+	if scan.config.General.SkipFailedScans > 0 {
+		scan.result.AddFailure(ip)
+	}
+}
+
+// scanJob is an IP of a site to test.
+type scanJob struct {
+	scan        *siteScan
+	destination string
+}
+
+func testOne(ch chan scanJob, wg *sync.WaitGroup) {
 	defer wg.Done()
-	for destination := range ch {
-		if (config.General.ScannedLimit > 0 && config.General.ScannedLimit < scanResult.Scanned()) ||
-			(config.General.FoundLimit > 0 && config.General.FoundLimit < scanResult.Found()) {
-			slog.Debug("The limit number of scans from configuration file has been reached, stop scanning!")
-			return
+	addMetric("ip_scanner_workers", "", 1)
+	defer addMetric("ip_scanner_workers", "", -1)
+	for job := range ch {
+		scanResult := job.scan.result
+		destination := job.destination
+		addMetric("ip_scanner_ips_tested_total", labels("site", job.scan.site.Name), 1)
+		if job.scan.limitReached() {
+			slog.Debug("The limit number of scans from configuration file has been reached, skip the IP!", "Site", job.scan.site.Name)
+			atomic.AddInt32(&job.scan.tested, 1)
+			continue
 		}
+		addMetric("ip_scanner_workers_busy", "", 1)
+		startTime := time.Now()
 		record := new(ScanRecord)
-		destinationPort := config.Ping.Port
-		success := pingOneIP(destination, destinationPort, config, record)
+		success := pingOneIP(destination, job.scan.site, record)
 		if success {
-			success = reqOneIP(destination, destinationPort, config, record)
+			success = reqOneIP(destination, job.scan.site, job.scan.site.HttpsURL, record)
 			if success {
 				scanResult.AddRecord(record)
 			} else {
 				slog.Debug(fmt.Sprintf("IP %s http test timeout", destination))
+				job.scan.addFailure(destination)
 			}
 			scanResult.IncScanCounter()
 		} else {
 			slog.Debug(fmt.Sprintf("IP %s ping test timeout", destination))
+			job.scan.addFailure(destination)
+		}
+		addMetric("ip_scanner_workers_busy", "", -1)
+		addMetric("ip_scanner_worker_busy_seconds_total", "", time.Since(startTime).Seconds())
+		atomic.AddInt32(&job.scan.tested, 1)
+	}
+}
+
+// Run scans the selected sites with one pool of workers and rate limit, writes the results
+// of every site to its own files and prints them, then a summary when there are several.
+// The error wraps ErrNoIPFound or ErrHostsWrite for the sites where they happened.
+func Run(config *Config) error {
+	progress := startProgress(config)
+	scans, err := scanSites(context.Background(), config, config.SelectedSites())
+	progress.Stop()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	exported := false
+	for _, scan := range scans {
+		scan.save()
+		scanRecords := scan.result.scanRecords
+		if len(scans) > 1 {
+			fmt.Printf("\n%s:\n", scan.site.Name)
+		}
+		var entries []HostsEntry
+		if len(scanRecords) > 0 {
+			entries = HostsEntries(scanRecords, scan.config)
+			if writeExports(scanRecords, entries, scan.config) {
+				exported = true
+			}
 		}
+		errs = append(errs, printResult(scanRecords, entries, scan.config))
 	}
+	if len(scans) > 1 {
+		printSummary(scans)
+	}
+	if exported {
+		errs = append(errs, RunReloadCmd(config.General.ReloadCmd))
+	}
+	return errors.Join(errs...)
 }
 
-func Run(config *Config) {
-	scanResult := new(ScanResult)
-	ips := GetIPs(config)
+// scanSites scans the named sites with one pool of workers and rate limit, until they are
+// done or ctx is, and sorts the records of every site by HttpRTT. The callers decide which
+// results to save. Nothing is scanned when the IPs of a site cannot be loaded.
+func scanSites(ctx context.Context, config *Config, names []string) ([]*siteScan, error) {
+	var scans []*siteScan
+	for _, name := range names {
+		siteConfig := config.ForSite(name)
+		ips, err := GetIPs(siteConfig)
+		if err != nil {
+			return nil, err
+		}
+		scans = append(scans, &siteScan{
+			config: siteConfig,
+			site:   RetrieveSiteCfg(siteConfig),
+			ips:    ips,
+			result: &ScanResult{site: name},
+		})
+	}
+	startStatus(scans)
 	workers := config.General.Workers
-	ch := make(chan string, len(ips))
+	ch := make(chan scanJob, workers)
 	var wg sync.WaitGroup
 	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go testOne(ch, config, scanResult, &wg)
-	}
-	for _, destination := range ips {
-		ch <- destination
+		go testOne(ch, &wg)
 	}
+	feedJobs(ctx, ch, scans, config.General.RateLimit)
 	// Sender close a channel to indicate that no more values will be sent.
 	close(ch)
 	wg.Wait()
-	scanRecords := scanResult.scanRecords
-	sort.Slice(scanRecords, func(i, j int) bool {
-		return scanRecords[i].HttpRTT < scanRecords[j].HttpRTT
-	})
-	writeToFile(scanRecords, config)
-	printResult(scanRecords, config)
+	finishStatus()
+	if ctx.Err() != nil {
+		// Interrupted, keep the results of the previous scan.
+		return nil, nil
+	}
+	for _, scan := range scans {
+		scanRecords := scan.result.scanRecords
+		// ScanStatus may be reading the records.
+		scan.result.recordMutex.Lock()
+		sort.Slice(scanRecords, func(i, j int) bool {
+			return scanRecords[i].HttpRTT < scanRecords[j].HttpRTT
+		})
+		scan.result.recordMutex.Unlock()
+		if scan.config.General.SkipFailedScans > 0 {
+			updateFailureHistory(scan.result, scan.config)
+		}
+	}
+	return scans, nil
+}
+
+// save writes the records of the scan to the output files of the site, and the ranges of
+// the fastest IPs to its CustomIPRangesFile when CustomRangesTop is set.
+func (scan *siteScan) save() {
+	writeToFile(scan.result.scanRecords, scan.config)
+	writeCustomRanges(scan.result.scanRecords, scan.config)
+}
+
+// feedJobs sends the IPs of the sites in turn, so they are scanned side by side, at most
+// rateLimit per second if it is positive. The sites that reached their limits are skipped.
+// It stops early when ctx is done.
+func feedJobs(ctx context.Context, ch chan scanJob, scans []*siteScan, rateLimit int) {
+	var tick <-chan time.Time
+	if rateLimit > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(rateLimit))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for i := 0; ; i++ {
+		pending := false
+		for _, scan := range scans {
+			if i >= len(scan.ips) || scan.limitReached() {
+				continue
+			}
+			pending = true
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case ch <- scanJob{scan: scan, destination: scan.ips[i]}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !pending {
+			return
+		}
+	}
+}
+
+// printSummary prints one line per site with the number of IPs scanned and found and the fastest.
+func printSummary(scans []*siteScan) {
+	fmt.Printf("\n%s\t%s\t%s\t%s\t%s\n", "Site", "Scanned", "Found", "FastestIP", "HttpRTT")
+	for _, scan := range scans {
+		fastest, rtt := "-", "-"
+		if records := scan.result.scanRecords; len(records) > 0 {
+			fastest, rtt = records[0].IP, fmt.Sprintf("%.f", records[0].HttpRTT)
+		}
+		fmt.Printf("%s\t%d\t%d\t%s\t%s\n", scan.site.Name, scan.result.Scanned(), scan.result.Found(), fastest, rtt)
+	}
+}
+
+func updateFailureHistory(scanResult *ScanResult, config *Config) {
+	site := RetrieveSiteCfg(config)
+	history, err := LoadFailureHistory(site.Site)
+	if err != nil {
+		slog.Error("load failure history failed", "error", err)
+		return
+	}
+	found := make([]string, 0, len(scanResult.scanRecords))
+	for _, record := range scanResult.scanRecords {
+		found = append(found, record.Addr())
+	}
+	history.Update(scanResult.failedIPs, found, config.General.SkipFailedScans)
+	if err = history.Save(site.Site); err != nil {
+		slog.Error("save failure history failed", "error", err)
+	}
 }
@@ -0,0 +1,105 @@
+package common
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// topRecords is the number of fastest records reported per site in the status.
+const topRecords = 10
+
+// Status is the progress of the current scan, or of the last one once it is done.
+type Status struct {
+	Running  bool         `json:"running"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Sites    []SiteStatus `json:"sites"`
+}
+
+// SiteStatus is the progress of the scan of one site.
+type SiteStatus struct {
+	Site string `json:"site"`
+	// Total is the number of IPs to test, Tested those done or skipped after a limit.
+	Total  int `json:"total"`
+	Tested int `json:"tested"`
+	// Scanned counts the IPs that passed the ping test, as in ScannedLimit.
+	Scanned int `json:"scanned"`
+	Found   int `json:"found"`
+	// Rate is the IPs tested per second, ETA the seconds left at that rate.
+	Rate float64 `json:"rate"`
+	ETA  float64 `json:"eta"`
+	// Done is set once every IP is tested or a limit is reached.
+	Done bool            `json:"done"`
+	Top  ScanRecordArray `json:"top"`
+}
+
+// scanning keeps the scans of the current or last run for ScanStatus.
+var scanning struct {
+	sync.Mutex
+	running  bool
+	started  time.Time
+	finished time.Time
+	scans    []*siteScan
+}
+
+func startStatus(scans []*siteScan) {
+	scanning.Lock()
+	defer scanning.Unlock()
+	scanning.running = true
+	scanning.started = time.Now()
+	scanning.finished = time.Time{}
+	scanning.scans = scans
+}
+
+func finishStatus() {
+	scanning.Lock()
+	defer scanning.Unlock()
+	scanning.running = false
+	scanning.finished = time.Now()
+}
+
+// ScanStatus returns the progress of the scan running in this process, or of the last one.
+func ScanStatus() Status {
+	scanning.Lock()
+	defer scanning.Unlock()
+	status := Status{Running: scanning.running, Started: scanning.started, Finished: scanning.finished}
+	elapsed := time.Since(scanning.started).Seconds()
+	if !scanning.running {
+		elapsed = scanning.finished.Sub(scanning.started).Seconds()
+	}
+	for _, scan := range scanning.scans {
+		site := SiteStatus{
+			Site:    scan.site.Name,
+			Total:   len(scan.ips),
+			Tested:  int(atomic.LoadInt32(&scan.tested)),
+			Scanned: scan.result.Scanned(),
+			Found:   scan.result.Found(),
+			Top:     scan.result.Top(topRecords),
+		}
+		if elapsed > 0 {
+			site.Rate = float64(site.Tested) / elapsed
+		}
+		site.Done = site.Tested >= site.Total || scan.limitReached()
+		if scanning.running && !site.Done && site.Rate > 0 {
+			site.ETA = float64(site.Total-site.Tested) / site.Rate
+		}
+		status.Sites = append(status.Sites, site)
+	}
+	return status
+}
+
+// Top returns the n fastest records found so far.
+func (result *ScanResult) Top(n int) ScanRecordArray {
+	result.recordMutex.Lock()
+	top := append(ScanRecordArray{}, result.scanRecords...)
+	result.recordMutex.Unlock()
+	sort.Slice(top, func(i, j int) bool {
+		return top[i].HttpRTT < top[j].HttpRTT
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
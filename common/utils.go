@@ -2,14 +2,14 @@ package common
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"log/slog"
+	"math/big"
 	"net/netip"
 	"os"
-	"reflect"
-	"runtime"
+	"path/filepath"
 	"strings"
 	"sync"
 )
@@ -30,9 +30,14 @@ func (arr *IPArray) AddIP(ip string) {
 
 func CIDRToIPs(cidrAddress string, iparr *IPArray, wg *sync.WaitGroup) {
 	defer wg.Done()
+	addCIDRIPs(cidrAddress, iparr)
+}
+
+func addCIDRIPs(cidrAddress string, iparr *IPArray) {
 	p, err := netip.ParsePrefix(cidrAddress)
 	if err != nil {
 		slog.Error("invalid cidr:", slog.String("CIDR", cidrAddress), slog.Any("Error", err))
+		return
 	}
 	p = p.Masked()
 	addr := p.Addr()
@@ -62,78 +67,83 @@ func loadCIDRs(config *Config) ([]string, error) {
 		slog.Warn("custom ip ranges file stat error: use default ip ranges file instead!", "error", err)
 		targetFile = ipRangesFile
 	}
-	f, err := os.Open(targetFile)
+	entries, err := ParseRangesFile(targetFile)
 	if err != nil {
-		slog.Error("Could not open ip address ranges:", "file", targetFile)
+		slog.Error("Could not load ip address ranges file:", "file", targetFile, "error", err)
 		return cidrs, err
 	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !withIPv6 && !isIPv4(line) {
-			continue
-		}
-		cidrs = append(cidrs, scanner.Text())
-	}
-	if err := scanner.Err(); err != nil {
-		slog.Error("Could not load ip address ranges file:", targetFile)
+	exclude, err := excludedPrefixes(config)
+	if err != nil {
+		slog.Error("Could not load excluded ip ranges:", "error", err)
 		return cidrs, err
 	}
-	return cidrs, err
+	groups := NormalizeRanges(entries, exclude, withIPv6)
+	excluded := new(big.Int).Sub(countGroups(NormalizeRanges(entries, nil, withIPv6)), countGroups(groups))
+	slog.Info("Excluded IPs:", "Count", excluded.String())
+	for _, group := range groups {
+		cidrs = append(cidrs, PrefixStrings(group.Prefixes)...)
+	}
+	return cidrs, nil
 }
 
-func GetIPs(config *Config) []string {
+// GetIPs returns the IPs to scan for the site of config. It fails when the ranges file
+// of the site cannot be read or has invalid lines, rather than scanning nothing.
+func GetIPs(config *Config) ([]string, error) {
 	var iparr IPArray
 	cidrs, err := loadCIDRs(config)
 	if err != nil {
-		slog.Error("get ips failed!")
-		return iparr.IPs
+		return nil, fmt.Errorf("load ip ranges of %s: %w", RetrieveSiteCfg(config).Name, err)
 	}
-	var wg sync.WaitGroup
+	// Enumerate in order, so the ranges with a higher weight are scanned first.
 	for _, cidrAddress := range cidrs {
-		wg.Add(1)
-		go CIDRToIPs(cidrAddress, &iparr, &wg)
+		addCIDRIPs(cidrAddress, &iparr)
 	}
-	wg.Wait()
 	slog.Info("Load IPs:", "Count", len(iparr.IPs))
-	return iparr.IPs
+	return iparr.IPs, nil
 }
 
-// RetrieveSiteCfg assumed that the site name must exist in the configuration file and no error handling required
-func RetrieveSiteCfg(config *Config) Site {
-	siteName := config.General.Site
-	sv := reflect.ValueOf(config.Sites)
-	sites := sv.Interface().([]Site)
-	for _, site := range sites {
-		if site.Name == siteName {
-			return site
-		}
+// RetrieveSiteCfg returns the site selected by General.Site, the first one if several
+// are selected, with its effective Ping and HTTP settings. The loader validates the
+// configuration first, so the site exists.
+func RetrieveSiteCfg(config *Config) SiteConfig {
+	var name string
+	if names := config.SelectedSites(); len(names) > 0 {
+		name = names[0]
 	}
-	return Site{}
+	site, _ := config.SiteByName(name)
+	return site
 }
 
 func writeToFile(scanRecords ScanRecordArray, config *Config) {
 	siteCfg := RetrieveSiteCfg(config)
-	outputFile := siteCfg.IPOutputFile
-	f, err := os.Create(outputFile)
-	if err != nil {
-		slog.Error("Failed to create file", err)
-	}
-	w := bufio.NewWriter(f)
+	var builder strings.Builder
 	for _, record := range scanRecords {
-		_, err := w.WriteString(record.IP + "\n")
-		if err != nil {
-			slog.Error("write to output file failed", "error", err)
-		}
+		builder.WriteString(record.IP + "\n")
 	}
-	err = w.Flush()
+	err := WriteFileAtomic(siteCfg.IPOutputFile, []byte(builder.String()), 0644)
 	if err != nil {
-		slog.Error("flush failed", "error", err)
+		slog.Error("write to output file failed", "file", siteCfg.IPOutputFile, "error", err)
+	}
+	err = saveResults(scanRecords, siteCfg.Site)
+	if err != nil {
+		slog.Error("write results failed", "error", err)
 	}
 }
 
-func printResult(scanRecords ScanRecordArray, config *Config) {
+// ErrNoIPFound and ErrHostsWrite tell the outcome of a scan besides success.
+var (
+	ErrNoIPFound  = errors.New("no available ip found")
+	ErrHostsWrite = errors.New("write hosts failed")
+)
+
+// Values of General.ApplyHosts.
+const (
+	ApplyHostsAsk = "ask"
+	ApplyHostsYes = "yes"
+	ApplyHostsNo  = "no"
+)
+
+func printResult(scanRecords ScanRecordArray, entries []HostsEntry, config *Config) error {
 	if len(scanRecords) == 0 {
 		site := RetrieveSiteCfg(config)
 		customIPRangesFile := site.CustomIPRangesFile
@@ -143,7 +153,7 @@ func printResult(scanRecords ScanRecordArray, config *Config) {
 		} else {
 			slog.Info("No available ip found!")
 		}
-		return
+		return fmt.Errorf("%w for %s", ErrNoIPFound, site.Name)
 	}
 	head := scanRecords
 	if len(head) > 10 {
@@ -153,135 +163,92 @@ func printResult(scanRecords ScanRecordArray, config *Config) {
 	for _, record := range head {
 		fmt.Printf("%s\t%s\t%.f\t%.f\n", record.IP, record.Protocol, record.PingRTT, record.HttpRTT)
 	}
-	fastestRecord := *scanRecords[0]
 	slog.Info("The fastest IP has been found:")
 	siteCfg := RetrieveSiteCfg(config)
-	for _, domain := range siteCfg.Domains {
-		fmt.Printf("%v\t%s\n", fastestRecord.IP, domain)
+	for _, entry := range entries {
+		fmt.Printf("%v\t%s\n", entry.IP, entry.Domain)
 	}
-	if askForConfirmation() {
-		writeToHosts(fastestRecord.IP, siteCfg.Domains)
+	if len(entries) > 0 && shouldApplyHosts(config.General.ApplyHosts) {
+		hostsFile, err := HostsPath(config)
+		if err == nil {
+			err = WriteToHosts(hostsFile, siteCfg.Name, entries)
+		}
+		if err != nil {
+			slog.Error("Modify hosts failed, please modify the hosts file yourself.", "error", err)
+			return fmt.Errorf("%w for %s: %v", ErrHostsWrite, siteCfg.Name, err)
+		}
 	}
+	return nil
 }
 
-func askForConfirmation() bool {
-	var confirm string
-	fmt.Println("Whether to write to the hosts file (yes/no):")
-	fmt.Scanln(&confirm)
-	switch strings.ToLower(confirm) {
-	case "y", "yes":
+// shouldApplyHosts tells whether to write the hosts file. Unless the mode is yes or no, it
+// asks, or declines if stdin is not a terminal such as under cron, systemd or CI.
+func shouldApplyHosts(mode string) bool {
+	switch mode {
+	case ApplyHostsYes:
 		return true
-	case "n", "no":
+	case ApplyHostsNo:
+		return false
+	}
+	if !isTerminal(os.Stdin) {
+		slog.Info("Stdin is not a terminal, the hosts file is not modified. Use --apply-hosts=yes to write it.")
 		return false
-	default:
-		slog.Info("Please type (y)es or (n)o and then press enter:")
-		return askForConfirmation()
 	}
+	return askForConfirmation()
 }
 
-func writeToHosts(ip string, domains []string) {
-	var hostsFile string
-	switch runtime.GOOS {
-	case "windows":
-		hostsFile = "C:\\Windows\\System32\\drivers\\etc\\hosts"
-	case "darwin":
-		hostsFile = "/private/etc/hosts"
-	case "linux":
-		hostsFile = "/etc/hosts"
-	default:
-		slog.Info("Your operating system is unknown, please configure hosts yourself.")
-		return
-	}
-	backupPath := "hosts"
-	err := Copy(hostsFile, backupPath)
-	if err != nil {
-		slog.Error("Backup hosts failed, please modify the hosts file yourself.", err)
-		return
-	}
-	err = modifyHosts(hostsFile, ip, domains)
-	if err != nil {
-		slog.Error("Modify hosts failed, please modify the hosts file yourself.", err)
-		return
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func askForConfirmation() bool {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Println("Whether to write to the hosts file (yes/no):")
+	for {
+		confirm, err := reader.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(confirm)) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+		if err != nil {
+			// stdin was closed before an answer.
+			return false
+		}
+		slog.Info("Please type (y)es or (n)o and then press enter:")
 	}
-	slog.Info("Successfully written to hosts file")
 }
 
-func Copy(srcPath, dstPath string) (err error) {
-	r, err := os.Open(srcPath)
-	if err != nil {
-		return err
+// WriteFileAtomic writes data to a temporary file in the directory of path and renames it
+// over path once it is completely written, so readers never see a partial file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
 	}
-	// ignore error: file was opened read-only.
-	defer r.Close()
-	w, err := os.Create(dstPath)
+	f, err := os.CreateTemp(dir, "."+base+".tmp*")
 	if err != nil {
 		return err
 	}
 	defer func() {
-		err := w.Close()
 		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
 		}
 	}()
-	_, err = io.Copy(w, r)
-	return err
-}
-
-// modifyHosts: only use for Google Translate
-func modifyHosts(hostsFile string, ip string, domains []string) error {
-	f, err := os.OpenFile(hostsFile, os.O_RDWR, 0644)
-	if err != nil {
+	if _, err = f.Write(data); err != nil {
 		return err
 	}
-	defer f.Close()
-
-	lineSeparator := "\n"
-	if runtime.GOOS == "windows" {
-		lineSeparator = "\r\n"
-	}
-	var builder strings.Builder
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strContainSlice(line, domains) {
-			continue
-		}
-		builder.WriteString(line + lineSeparator)
-	}
-	builder.WriteString(lineSeparator)
-	for _, domain := range domains {
-		line := fmt.Sprintf("%s\t%s", ip, domain) + lineSeparator
-		builder.WriteString(line)
-	}
-	err = f.Truncate(0)
-	if err != nil {
+	if err = f.Sync(); err != nil {
 		return err
 	}
-	_, err = f.Seek(0, 0)
-	if err != nil {
+	if err = f.Chmod(perm); err != nil {
 		return err
 	}
-	_, err = f.WriteString(builder.String())
-	if err != nil {
+	if err = f.Close(); err != nil {
 		return err
 	}
-	return nil
-}
-
-func AssertSiteName(config *Config) bool {
-	siteName := config.General.Site
-	for _, site := range config.Sites {
-		if site.Name == siteName {
-			return true
-		}
-	}
-	return false
-}
-
-func strContainSlice(s string, ls []string) bool {
-	for _, substr := range ls {
-		if strings.Contains(s, substr) {
-			return true
-		}
-	}
-	return false
+	return os.Rename(f.Name(), path)
 }
@@ -0,0 +1,260 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pingProtocols are the values of Ping.Protocol that pingOneIP knows.
+var pingProtocols = []string{"icmp", "tcp", "udp"}
+
+// Validate checks the configuration and returns every problem found, joined into one
+// error, or nil if there is none.
+func (config *Config) Validate() error {
+	var errs []error
+	for _, key := range config.UnknownKeys {
+		errs = append(errs, fmt.Errorf("unknown key %s", key))
+	}
+	errs = append(errs, config.validateGeneral()...)
+	errs = append(errs, validateTests(config.Ping, config.HTTP)...)
+	errs = append(errs, config.validateDNS()...)
+	errs = append(errs, config.validateDaemon()...)
+	if config.API.Listen != "" {
+		if _, _, err := net.SplitHostPort(config.API.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("api.listen: %w", err))
+		}
+	}
+	if config.Proxy.Listen != "" {
+		if _, _, err := net.SplitHostPort(config.Proxy.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("proxy.listen: %w", err))
+		}
+	}
+	names := make(map[string]bool)
+	for i, site := range config.Sites {
+		if site.Name == "" {
+			errs = append(errs, fmt.Errorf("sites[%d]: Name is empty", i))
+		} else if names[site.Name] {
+			errs = append(errs, fmt.Errorf("sites[%d]: site %s is configured twice", i, site.Name))
+		}
+		names[site.Name] = true
+		siteErrs := site.validate()
+		if site.Ping != (PingOverride{}) || site.HTTP != (HTTPOverride{}) {
+			resolved := config.resolve(site)
+			siteErrs = append(siteErrs, validateTests(resolved.Ping, resolved.HTTP)...)
+		}
+		for _, err := range siteErrs {
+			errs = append(errs, fmt.Errorf("sites[%d] %s: %w", i, site.Name, err))
+		}
+	}
+	names = make(map[string]bool)
+	if len(config.SelectedSites()) == 0 {
+		errs = append(errs, errors.New("general.site is empty, set it to one or more of the configured Sites"))
+	}
+	for _, name := range config.SelectedSites() {
+		if names[name] {
+			errs = append(errs, fmt.Errorf("general.site: site %s is selected twice", name))
+		} else if site, ok := config.SiteByName(name); !ok {
+			errs = append(errs, fmt.Errorf("general.site: site %s is not configured under Sites", name))
+		} else if err := site.validateRangesFile(); err != nil {
+			errs = append(errs, fmt.Errorf("site %s: %w", site.Name, err))
+		}
+		names[name] = true
+	}
+	return errors.Join(errs...)
+}
+
+func (config *Config) validateGeneral() []error {
+	var errs []error
+	general := config.General
+	if general.Workers <= 0 {
+		errs = append(errs, fmt.Errorf("general.workers must be positive, got %d", general.Workers))
+	}
+	if general.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("general.ratelimit must not be negative, got %d", general.RateLimit))
+	}
+	switch general.ApplyHosts {
+	case "", ApplyHostsAsk, ApplyHostsYes, ApplyHostsNo:
+	default:
+		errs = append(errs, fmt.Errorf("general.applyhosts must be yes, no or ask, got %q", general.ApplyHosts))
+	}
+	if general.HostsIPs < 0 {
+		errs = append(errs, fmt.Errorf("general.hostsips must not be negative, got %d", general.HostsIPs))
+	}
+	for _, format := range general.Exports {
+		if _, ok := exporters[format]; !ok {
+			errs = append(errs, fmt.Errorf("general.exports: unknown format %q, available: %s", format, strings.Join(ExportFormats(), ", ")))
+		}
+	}
+	if general.SkipFailedScans < 0 {
+		errs = append(errs, fmt.Errorf("general.skipfailedscans must not be negative, got %d", general.SkipFailedScans))
+	}
+	if general.CustomRangesTop < 0 {
+		errs = append(errs, fmt.Errorf("general.customrangestop must not be negative, got %d", general.CustomRangesTop))
+	}
+	if general.CustomRangesPrefix < 0 || general.CustomRangesPrefix > 128 {
+		errs = append(errs, fmt.Errorf("general.customrangesprefix must be between 0 and 128, got %d", general.CustomRangesPrefix))
+	}
+	if general.HostsFile != "" {
+		if _, err := os.Stat(general.HostsFile); err != nil {
+			errs = append(errs, fmt.Errorf("general.hostsfile: %w", err))
+		}
+	}
+	for _, file := range general.ExcludeFiles {
+		if _, err := os.Stat(file); err != nil {
+			errs = append(errs, fmt.Errorf("general.excludefiles: %w", err))
+		}
+	}
+	if general.MetricsFile != "" {
+		if _, err := os.Stat(filepath.Dir(general.MetricsFile)); err != nil {
+			errs = append(errs, fmt.Errorf("general.metricsfile: %w", err))
+		}
+	}
+	return errs
+}
+
+func (config *Config) validateDNS() []error {
+	var errs []error
+	dns := config.DNS
+	if dns.Listen != "" {
+		if _, _, err := net.SplitHostPort(dns.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("dns.listen: %w", err))
+		}
+	}
+	if dns.Upstream != "" {
+		if _, _, err := net.SplitHostPort(dns.Upstream); err != nil {
+			errs = append(errs, fmt.Errorf("dns.upstream: %w", err))
+		}
+	}
+	if dns.TTL < 0 {
+		errs = append(errs, fmt.Errorf("dns.ttl must not be negative, got %d", dns.TTL))
+	}
+	return errs
+}
+
+func (config *Config) validateDaemon() []error {
+	var errs []error
+	daemon := config.Daemon
+	if daemon.CheckInterval < 0 {
+		errs = append(errs, fmt.Errorf("daemon.checkinterval must not be negative, got %s", daemon.CheckInterval))
+	}
+	if daemon.RescanInterval < 0 {
+		errs = append(errs, fmt.Errorf("daemon.rescaninterval must not be negative, got %s", daemon.RescanInterval))
+	}
+	if daemon.LatencySLO < 0 {
+		errs = append(errs, fmt.Errorf("daemon.latencyslo must not be negative, got %d", daemon.LatencySLO))
+	}
+	if daemon.FailedChecks < 0 {
+		errs = append(errs, fmt.Errorf("daemon.failedchecks must not be negative, got %d", daemon.FailedChecks))
+	}
+	if daemon.MinImprovement < 0 || daemon.MinImprovement >= 100 {
+		errs = append(errs, fmt.Errorf("daemon.minimprovement must be between 0 and 99, got %d", daemon.MinImprovement))
+	}
+	if daemon.MetricsListen != "" {
+		if _, _, err := net.SplitHostPort(daemon.MetricsListen); err != nil {
+			errs = append(errs, fmt.Errorf("daemon.metricslisten: %w", err))
+		}
+	}
+	return errs
+}
+
+// validateTests checks the settings of the ping and http tests.
+func validateTests(ping PingConfig, http HTTPConfig) []error {
+	var errs []error
+	if !contains(pingProtocols, ping.Protocol) {
+		errs = append(errs, fmt.Errorf("ping.protocol must be one of %s, got %q", strings.Join(pingProtocols, ", "), ping.Protocol))
+	}
+	if ping.Port == 0 && ping.Protocol != "icmp" {
+		errs = append(errs, errors.New("ping.port must be set for tcp and udp"))
+	}
+	if ping.Count <= 0 {
+		errs = append(errs, fmt.Errorf("ping.count must be positive, got %d", ping.Count))
+	}
+	if ping.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("ping.timeout must be positive, got %s", ping.Timeout))
+	}
+	if http.Port == 0 {
+		errs = append(errs, errors.New("http.port must be set"))
+	}
+	if http.Count <= 0 {
+		errs = append(errs, fmt.Errorf("http.count must be positive, got %d", http.Count))
+	}
+	if http.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("http.timeout must be positive, got %s", http.Timeout))
+	}
+	return errs
+}
+
+func (site Site) validate() []error {
+	var errs []error
+	if site.IPRangesFile == "" {
+		errs = append(errs, errors.New("IPRangesFile is empty"))
+	} else if _, err := os.Stat(filepath.Dir(site.IPRangesFile)); err != nil {
+		errs = append(errs, fmt.Errorf("IPRangesFile: %w", err))
+	}
+	if site.IPOutputFile == "" {
+		errs = append(errs, errors.New("IPOutputFile is empty"))
+	}
+	if site.IPRangesAPI != "" {
+		if err := validateURL(site.IPRangesAPI, "http", "https"); err != nil {
+			errs = append(errs, fmt.Errorf("IPRangesAPI: %w", err))
+		}
+	}
+	if err := validateURL(site.HttpsURL, "https"); err != nil {
+		errs = append(errs, fmt.Errorf("HttpsURL: %w", err))
+	}
+	return errs
+}
+
+// validateRangesFile parses the ranges file that will be scanned, the CustomIPRangesFile
+// if it exists, or else the IPRangesFile. A missing IPRangesFile is fine if it can be
+// fetched from the IPRangesAPI.
+func (site Site) validateRangesFile() error {
+	file := site.IPRangesFile
+	if site.CustomIPRangesFile != "" {
+		if _, err := os.Stat(site.CustomIPRangesFile); err == nil {
+			file = site.CustomIPRangesFile
+		}
+	}
+	if file == "" {
+		return nil
+	}
+	_, err := ParseRangesFile(file)
+	if os.IsNotExist(err) {
+		if site.IPRangesAPI != "" {
+			return nil
+		}
+		return fmt.Errorf("IPRangesFile %s does not exist and there is no IPRangesAPI to fetch it from", file)
+	}
+	return err
+}
+
+func validateURL(rawURL string, schemes ...string) error {
+	if rawURL == "" {
+		return errors.New("empty url")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if !contains(schemes, u.Scheme) {
+		return fmt.Errorf("url %q must start with %s://", rawURL, strings.Join(schemes, ":// or "))
+	}
+	if u.Host == "" {
+		return fmt.Errorf("url %q has no host", rawURL)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ module github.com/csyezheng/ip-scanner
 go 1.20
 
 require (
+	github.com/mitchellh/mapstructure v1.5.0
 	github.com/spf13/viper v1.17.0
 	golang.org/x/net v0.17.0
 )
@@ -11,7 +12,6 @@ require (
 	github.com/fsnotify/fsnotify v1.6.0 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/magiconair/properties v1.8.7 // indirect
-	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/pelletier/go-toml/v2 v2.1.0 // indirect
 	github.com/rogpeppe/go-internal v1.11.0 // indirect
 	github.com/sagikazarmark/locafero v0.3.0 // indirect
@@ -0,0 +1,68 @@
+package sites
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/csyezheng/ip-scanner/common"
+	"log/slog"
+)
+
+type awsResponse struct {
+	SyncToken  string `json:"syncToken"`
+	CreateDate string `json:"createDate"`
+	Prefixes   []struct {
+		IPPrefix string `json:"ip_prefix"`
+		Region   string `json:"region"`
+		Service  string `json:"service"`
+	} `json:"prefixes"`
+	Ipv6Prefixes []struct {
+		Ipv6Prefix string `json:"ipv6_prefix"`
+		Region     string `json:"region"`
+		Service    string `json:"service"`
+	} `json:"ipv6_prefixes"`
+}
+
+// aws reads https://ip-ranges.amazonaws.com/ip-ranges.json, keeping the prefixes
+// whose service is in Site.Services (e.g. CLOUDFRONT) and region is in Site.Regions.
+type aws struct {
+	httpFetcher
+}
+
+func init() {
+	Register(aws{httpFetcher{accept: "application/json"}})
+}
+
+func (aws) Name() string {
+	return "AWS"
+}
+
+func (aws) Version(site common.Site, body []byte) string {
+	var res awsResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return ""
+	}
+	return res.SyncToken
+}
+
+func (aws) Parse(site common.Site, body []byte) ([]string, error) {
+	var res awsResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		slog.Error("Failed to decode aws JSON", "error", err)
+		return nil, err
+	}
+	var ipCIDRs []string
+	for _, item := range res.Prefixes {
+		if matchFilter(site.Services, item.Service) && matchFilter(site.Regions, item.Region) {
+			ipCIDRs = append(ipCIDRs, item.IPPrefix)
+		}
+	}
+	for _, item := range res.Ipv6Prefixes {
+		if matchFilter(site.Services, item.Service) && matchFilter(site.Regions, item.Region) {
+			ipCIDRs = append(ipCIDRs, item.Ipv6Prefix)
+		}
+	}
+	if len(ipCIDRs) == 0 {
+		return nil, fmt.Errorf("no aws prefixes match services %v and regions %v", site.Services, site.Regions)
+	}
+	return dedupe(ipCIDRs), nil
+}
@@ -0,0 +1,65 @@
+package sites
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/csyezheng/ip-scanner/common"
+	"log/slog"
+	"strconv"
+)
+
+type azureResponse struct {
+	ChangeNumber int    `json:"changeNumber"`
+	Cloud        string `json:"cloud"`
+	Values       []struct {
+		Name       string `json:"name"`
+		ID         string `json:"id"`
+		Properties struct {
+			ChangeNumber    int      `json:"changeNumber"`
+			Region          string   `json:"region"`
+			Platform        string   `json:"platform"`
+			SystemService   string   `json:"systemService"`
+			AddressPrefixes []string `json:"addressPrefixes"`
+		} `json:"properties"`
+	} `json:"values"`
+}
+
+// azure reads the Azure Service Tags JSON (ServiceTags_Public_<date>.json), keeping
+// the tags named in Site.Services (e.g. AzureFrontDoor.Frontend) and the regions in Site.Regions.
+type azure struct {
+	httpFetcher
+}
+
+func init() {
+	Register(azure{httpFetcher{accept: "application/json"}})
+}
+
+func (azure) Name() string {
+	return "Azure"
+}
+
+func (azure) Version(site common.Site, body []byte) string {
+	var res azureResponse
+	if err := json.Unmarshal(body, &res); err != nil || res.ChangeNumber == 0 {
+		return ""
+	}
+	return strconv.Itoa(res.ChangeNumber)
+}
+
+func (azure) Parse(site common.Site, body []byte) ([]string, error) {
+	var res azureResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		slog.Error("Failed to decode azure JSON", "error", err)
+		return nil, err
+	}
+	var ipCIDRs []string
+	for _, value := range res.Values {
+		if matchFilter(site.Services, value.Name) && matchFilter(site.Regions, value.Properties.Region) {
+			ipCIDRs = append(ipCIDRs, value.Properties.AddressPrefixes...)
+		}
+	}
+	if len(ipCIDRs) == 0 {
+		return nil, fmt.Errorf("no azure service tags match services %v and regions %v", site.Services, site.Regions)
+	}
+	return dedupe(ipCIDRs), nil
+}
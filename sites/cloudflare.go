@@ -4,62 +4,48 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/csyezheng/ip-scanner/common"
-	"io"
 	"log/slog"
-	"net/http"
-	"os"
-	"strings"
 )
 
-type cfResponse struct {
-	Result struct {
-		Ipv4Cidrs []string `json:"ipv4_cidrs"`
-		Ipv6Cidrs []string `json:"ipv6_cidrs"`
-		Etag      string   `json:"etag"`
-	} `json:"result"`
-	Success  bool  `json:"success"`
-	Errors   []any `json:"errors"`
-	Messages []any `json:"messages"`
+// cloudflareSelectors reads https://api.cloudflare.com/client/v4/ips. The Cloudflare
+// provider is the same as configuring the site with:
+//
+//	Provider = "Generic"
+//	Selectors = ["result.ipv4_cidrs[*]", "result.ipv6_cidrs[*]"]
+//	VersionSelector = "result.etag"
+//
+// except that it also reports the API errors when success is false.
+var cloudflareSelectors = []string{"result.ipv4_cidrs[*]", "result.ipv6_cidrs[*]"}
+
+type cloudflare struct {
+	generic
 }
 
-func FetchCFIPRanges(config *common.Config) error {
-	site := common.RetrieveSiteCfg(config)
-	url := site.IPRangesAPI
-	dest := site.IPRangesFile
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		slog.Error("error on response.\n[ERROR] -", err)
-		return err
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		slog.Error("error while reading the response bytes:", err)
-		return err
+func init() {
+	Register(cloudflare{generic{httpFetcher{accept: "application/json"}}})
+}
+
+func (cloudflare) Name() string {
+	return "Cloudflare"
+}
+
+func (p cloudflare) Parse(site common.Site, body []byte) ([]string, error) {
+	var res struct {
+		Success bool  `json:"success"`
+		Errors  []any `json:"errors"`
 	}
-	var res cfResponse
-	if err = json.Unmarshal(body, &res); err != nil {
-		slog.Error("Failed to decode release JSON. Error:", err)
-		return err
+	if err := json.Unmarshal(body, &res); err != nil {
+		slog.Error("Failed to decode cloudflare JSON", "error", err)
+		return nil, err
 	}
-	if res.Success {
-		ipv4CIDRs := res.Result.Ipv4Cidrs
-		ipv6CIDRs := res.Result.Ipv6Cidrs
-		ipCIDRs := append(ipv4CIDRs, ipv6CIDRs...)
-		f, err := os.OpenFile(dest, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
-		if err != nil {
-			return err
-		}
-		_, err = f.WriteString(strings.Join(ipCIDRs, "\n"))
-		if err != nil {
-			return err
-		}
-	} else {
-		return fmt.Errorf("fetch failed, cloudflare return: %v", res)
+	if !res.Success {
+		return nil, fmt.Errorf("fetch failed, cloudflare return errors: %v", res.Errors)
 	}
-	slog.Info("fetch success, the latest IP segment has been saved in", "file", dest)
-	return nil
+	site.Selectors = cloudflareSelectors
+	return p.generic.Parse(site, body)
+}
+
+func (p cloudflare) Version(site common.Site, body []byte) string {
+	site.VersionSelector = "result.etag"
+	return p.generic.Version(site, body)
 }
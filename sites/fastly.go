@@ -0,0 +1,38 @@
+package sites
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/csyezheng/ip-scanner/common"
+	"log/slog"
+)
+
+type fastlyResponse struct {
+	Addresses     []string `json:"addresses"`
+	Ipv6Addresses []string `json:"ipv6_addresses"`
+}
+
+// fastly reads https://api.fastly.com/public-ip-list
+type fastly struct {
+	httpFetcher
+}
+
+func init() {
+	Register(fastly{httpFetcher{accept: "application/json"}})
+}
+
+func (fastly) Name() string {
+	return "Fastly"
+}
+
+func (fastly) Parse(site common.Site, body []byte) ([]string, error) {
+	var res fastlyResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		slog.Error("Failed to decode fastly JSON", "error", err)
+		return nil, err
+	}
+	if len(res.Addresses) == 0 && len(res.Ipv6Addresses) == 0 {
+		return nil, fmt.Errorf("fetch failed, fastly returned no addresses")
+	}
+	return append(res.Addresses, res.Ipv6Addresses...), nil
+}
@@ -0,0 +1,145 @@
+package sites
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+const (
+	// fetchTimeout bounds a single attempt, including reading the body.
+	fetchTimeout = 30 * time.Second
+	// fetchAttempts is how many times a request is tried before giving up.
+	fetchAttempts = 4
+	// maxBodySize guards against endless responses, published lists are a few megabytes.
+	maxBodySize = 64 << 20
+)
+
+// fetchBackoff is the wait after the first failed attempt, doubled after each one.
+var fetchBackoff = time.Second
+
+var fetchClient = &http.Client{Timeout: fetchTimeout}
+
+// statusError is returned for unexpected HTTP status codes.
+type statusError struct {
+	url    string
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("fetch %s: unexpected http status %d %s", e.url, e.status, http.StatusText(e.status))
+}
+
+// retryable reports whether another attempt may succeed: network errors, timeouts,
+// rate limiting and server errors are retried, other client errors and urls that no
+// request can be made of are not.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.status == http.StatusTooManyRequests || se.status == http.StatusRequestTimeout || se.status >= 500
+	}
+	var ue *url.Error
+	if errors.As(err, &ue) && ue.Op == "parse" {
+		return false
+	}
+	return true
+}
+
+// fetchURL GETs url with a timeout, retrying with exponential backoff. The request is made
+// conditional on previous, and a 304 response is returned as a NotModified document.
+func fetchURL(url string, accept string, previous FetchMeta) (*Document, error) {
+	if err := checkURL(url); err != nil {
+		return nil, err
+	}
+	backoff := fetchBackoff
+	var err error
+	for attempt := 1; attempt <= fetchAttempts; attempt++ {
+		var doc *Document
+		doc, err = fetchOnce(url, accept, previous)
+		if err == nil {
+			return doc, nil
+		}
+		if !retryable(err) || attempt == fetchAttempts {
+			break
+		}
+		slog.Warn("fetch failed, retrying", "url", url, "attempt", attempt, "wait", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	slog.Error("fetch failed", "url", url, "error", err)
+	return nil, err
+}
+
+// checkURL fails for an url that no request can be made of, which is not worth retrying.
+func checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("fetch %q: not an http or https url", rawURL)
+	}
+	return nil
+}
+
+func fetchOnce(url string, accept string, previous FetchMeta) (*Document, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Add("Accept", accept)
+	}
+	if previous.URL == url {
+		if previous.ETag != "" {
+			req.Header.Set("If-None-Match", previous.ETag)
+		}
+		if previous.LastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.LastModified)
+		}
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	doc := &Document{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		doc.NotModified = true
+		return doc, nil
+	default:
+		return nil, &statusError{url: url, status: resp.StatusCode}
+	}
+	doc.Body, err = io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return nil, fmt.Errorf("read response of %s: %w", url, err)
+	}
+	if len(doc.Body) == 0 {
+		return nil, fmt.Errorf("fetch %s: empty response", url)
+	}
+	return doc, nil
+}
+
+// validateIPRanges checks that every line is a valid CIDR prefix and that there is at least one.
+func validateIPRanges(ipCIDRs []string) error {
+	if len(ipCIDRs) == 0 {
+		return errors.New("no ip ranges")
+	}
+	var errs []error
+	for i, cidr := range ipCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", i+1, err))
+		}
+	}
+	return errors.Join(errs...)
+}
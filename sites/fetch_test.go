@@ -0,0 +1,88 @@
+package sites
+
+import (
+	"github.com/csyezheng/ip-scanner/common"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchURLRetries(t *testing.T) {
+	defer func(backoff time.Duration) { fetchBackoff = backoff }(fetchBackoff)
+	fetchBackoff = time.Millisecond
+	tests := []struct {
+		name     string
+		statuses []int // answered in turn, the last one from then on
+		wantErr  string
+		wantHits int32
+	}{
+		{"ok", []int{http.StatusOK}, "", 1},
+		{"5xx then ok", []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}, "", 3},
+		{"429 then ok", []int{http.StatusTooManyRequests, http.StatusOK}, "", 2},
+		{"5xx", []int{http.StatusInternalServerError}, "unexpected http status 500", fetchAttempts},
+		{"404", []int{http.StatusNotFound, http.StatusOK}, "unexpected http status 404", 1},
+		{"403", []int{http.StatusForbidden, http.StatusOK}, "unexpected http status 403", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(atomic.AddInt32(&hits, 1))
+				if n > len(tt.statuses) {
+					n = len(tt.statuses)
+				}
+				w.WriteHeader(tt.statuses[n-1])
+				w.Write([]byte("192.0.2.0/24\n"))
+			}))
+			defer server.Close()
+			doc, err := fetchURL(server.URL, "", FetchMeta{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("fetchURL() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil || string(doc.Body) != "192.0.2.0/24\n" {
+				t.Errorf("fetchURL() = %v, %v", doc, err)
+			}
+			if got := atomic.LoadInt32(&hits); got != tt.wantHits {
+				t.Errorf("fetchURL() made %d requests, want %d", got, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestFetchURLInvalid(t *testing.T) {
+	defer func(backoff time.Duration) { fetchBackoff = backoff }(fetchBackoff)
+	// Retrying would take far longer than the test allows.
+	fetchBackoff = time.Hour
+	for _, url := range []string{"", "example.com/ranges.json", "ftp://example.com/ranges.txt", "http://", "http://[::1", "https://exa mple.com/"} {
+		t.Run(url, func(t *testing.T) {
+			done := make(chan error, 1)
+			go func() {
+				_, err := fetchURL(url, "", FetchMeta{})
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Errorf("fetchURL(%q) did not fail", url)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("fetchURL(%q) is retried", url)
+			}
+		})
+	}
+}
+
+func TestFetchIPRangesWithoutAPI(t *testing.T) {
+	config := new(common.Config)
+	config.General.Site = "Test"
+	config.Sites = []common.Site{{Name: "Test", Provider: "Generic", IPRangesFile: filepath.Join(t.TempDir(), "ranges.txt")}}
+	err := FetchIPRanges(config, false)
+	if err == nil || !strings.Contains(err.Error(), "no IPRangesAPI") {
+		t.Errorf("FetchIPRanges() error = %v, want no IPRangesAPI", err)
+	}
+}
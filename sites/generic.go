@@ -0,0 +1,284 @@
+package sites
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/csyezheng/ip-scanner/common"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// generic reads a ranges document described entirely by the site configuration,
+// so a new published list can be used without writing a provider:
+//
+//   - Site.Selectors are JSONPath-style selectors such as prefixes[*].ipv4Prefix,
+//     whose string results are the prefixes.
+//   - Otherwise Site.Pattern is a regular expression run over the plain text. The first
+//     capture group is used if it has one. Without a pattern, every word of the text that
+//     is an address, a prefix or an address range such as 10.0.0.1-10.0.0.5 is taken.
+//
+// Selectors are dot separated field names, optionally starting with $. A field may be
+// followed by [*] for every element, [n] for the n-th element or [?key==value] for the
+// elements whose key equals value. * alone selects every value of an object.
+type generic struct {
+	httpFetcher
+}
+
+func init() {
+	Register(generic{})
+}
+
+func (generic) Name() string {
+	return "Generic"
+}
+
+func (generic) Parse(site common.Site, body []byte) ([]string, error) {
+	var ipCIDRs []string
+	var err error
+	if len(site.Selectors) > 0 {
+		ipCIDRs, err = parseJSON(body, site.Selectors)
+	} else {
+		ipCIDRs, err = parseText(body, site.Pattern)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(ipCIDRs) == 0 {
+		return nil, fmt.Errorf("no ip ranges found in %s for site %s", site.IPRangesAPI, site.Name)
+	}
+	return dedupe(ipCIDRs), nil
+}
+
+func (generic) Version(site common.Site, body []byte) string {
+	if site.VersionSelector == "" {
+		return ""
+	}
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return ""
+	}
+	values, err := selectJSON(doc, site.VersionSelector)
+	if err != nil || len(values) == 0 {
+		return ""
+	}
+	return fmt.Sprint(values[0])
+}
+
+func parseJSON(body []byte, selectors []string) ([]string, error) {
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		slog.Error("Failed to decode JSON", "error", err)
+		return nil, err
+	}
+	var ipCIDRs []string
+	for _, selector := range selectors {
+		values, err := selectJSON(doc, selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			switch v := value.(type) {
+			case string:
+				ipCIDRs = append(ipCIDRs, v)
+			case []any:
+				for _, item := range v {
+					if s, ok := item.(string); ok {
+						ipCIDRs = append(ipCIDRs, s)
+					}
+				}
+			default:
+				slog.Debug("selector matched a value that is not a string", "selector", selector, "value", v)
+			}
+		}
+	}
+	return ipCIDRs, nil
+}
+
+func parseText(body []byte, pattern string) ([]string, error) {
+	if pattern == "" {
+		var ipCIDRs []string
+		for _, word := range textWords(string(body)) {
+			if prefixes, err := common.ParseRange(word); err == nil {
+				ipCIDRs = append(ipCIDRs, common.PrefixStrings(prefixes)...)
+			}
+		}
+		return ipCIDRs, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	var ipCIDRs []string
+	for _, match := range re.FindAllStringSubmatch(string(body), -1) {
+		text := match[0]
+		if len(match) > 1 {
+			text = match[1]
+		}
+		if prefixes, err := common.ParseRange(text); err == nil {
+			ipCIDRs = append(ipCIDRs, common.PrefixStrings(prefixes)...)
+		} else {
+			slog.Debug("pattern matched something that is not an ip range", "match", text)
+		}
+	}
+	return ipCIDRs, nil
+}
+
+// textWords splits text into the words that may be an address, a prefix or an address
+// range. A label in front of an address, as in ip:1.2.3.4, is dropped.
+func textWords(text string) []string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || strings.ContainsRune(":./-", r))
+	})
+	var words []string
+	for _, field := range fields {
+		// The end of a sentence or a dash around the word.
+		field = strings.Trim(field, ".-")
+		label, rest, ok := strings.Cut(field, ":")
+		if ok && !strings.ContainsAny(label, "./") && strings.Trim(label, "0123456789abcdefABCDEF") != "" {
+			field = rest
+		}
+		if strings.ContainsAny(field, "0123456789") {
+			words = append(words, field)
+		}
+	}
+	return words
+}
+
+type selectorStep struct {
+	field    string // object field, "*" for every value
+	index    int    // array index, used when bracket is "index"
+	bracket  string // "", "*", "index" or "filter"
+	key      string // filter key
+	expected string // filter value
+}
+
+func parseSelector(selector string) ([]selectorStep, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(selector), "$")
+	var steps []selectorStep
+	for s != "" {
+		s = strings.TrimPrefix(s, ".")
+		end := strings.IndexAny(s, ".[")
+		if end < 0 {
+			end = len(s)
+		}
+		if end > 0 {
+			steps = append(steps, selectorStep{field: s[:end]})
+			s = s[end:]
+		}
+		for strings.HasPrefix(s, "[") {
+			closing := strings.Index(s, "]")
+			if closing < 0 {
+				return nil, fmt.Errorf("invalid selector %q: missing ]", selector)
+			}
+			step, err := parseBracket(s[1:closing])
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector %q: %w", selector, err)
+			}
+			steps = append(steps, step)
+			s = s[closing+1:]
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	return steps, nil
+}
+
+func parseBracket(content string) (selectorStep, error) {
+	switch {
+	case content == "*":
+		return selectorStep{bracket: "*"}, nil
+	case strings.HasPrefix(content, "?"):
+		cond := strings.Trim(strings.TrimPrefix(content, "?"), "()")
+		key, expected, ok := strings.Cut(cond, "==")
+		if !ok {
+			return selectorStep{}, fmt.Errorf("filter %q must be key==value", content)
+		}
+		key = strings.TrimPrefix(strings.TrimSpace(key), "@.")
+		expected = strings.Trim(strings.TrimSpace(expected), `'"`)
+		return selectorStep{bracket: "filter", key: key, expected: expected}, nil
+	default:
+		index, err := strconv.Atoi(content)
+		if err != nil {
+			return selectorStep{}, fmt.Errorf("unsupported bracket [%s]", content)
+		}
+		return selectorStep{bracket: "index", index: index}, nil
+	}
+}
+
+// selectJSON returns every value of the decoded JSON document matched by selector.
+func selectJSON(doc any, selector string) ([]any, error) {
+	steps, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	current := []any{doc}
+	for _, step := range steps {
+		var next []any
+		for _, value := range current {
+			next = append(next, step.apply(value)...)
+		}
+		current = next
+	}
+	return current, nil
+}
+
+func (step selectorStep) apply(value any) []any {
+	switch {
+	case step.field == "*":
+		return children(value)
+	case step.field != "":
+		if obj, ok := value.(map[string]any); ok {
+			if child, ok := obj[step.field]; ok {
+				return []any{child}
+			}
+		}
+		return nil
+	case step.bracket == "*":
+		return children(value)
+	case step.bracket == "index":
+		if arr, ok := value.([]any); ok {
+			index := step.index
+			if index < 0 {
+				index += len(arr)
+			}
+			if index >= 0 && index < len(arr) {
+				return []any{arr[index]}
+			}
+		}
+		return nil
+	case step.bracket == "filter":
+		var matched []any
+		for _, child := range children(value) {
+			obj, ok := child.(map[string]any)
+			if ok && strings.EqualFold(fmt.Sprint(obj[step.key]), step.expected) {
+				matched = append(matched, child)
+			}
+		}
+		return matched
+	}
+	return nil
+}
+
+// children returns the elements of an array or the values of an object, ordered by key.
+func children(value any) []any {
+	switch v := value.(type) {
+	case []any:
+		return v
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]any, 0, len(v))
+		for _, key := range keys {
+			values = append(values, v[key])
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package sites
+
+import (
+	"encoding/json"
+	"github.com/csyezheng/ip-scanner/common"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     []selectorStep
+		wantErr  string
+	}{
+		{"prefixes", []selectorStep{{field: "prefixes"}}, ""},
+		{"$.prefixes", []selectorStep{{field: "prefixes"}}, ""},
+		{"$", nil, "empty selector"},
+		{"", nil, "empty selector"},
+		{"prefixes[*].ip_prefix", []selectorStep{{field: "prefixes"}, {bracket: "*"}, {field: "ip_prefix"}}, ""},
+		{"values[0]", []selectorStep{{field: "values"}, {bracket: "index", index: 0}}, ""},
+		{"values[-1]", []selectorStep{{field: "values"}, {bracket: "index", index: -1}}, ""},
+		{"[1][2]", []selectorStep{{bracket: "index", index: 1}, {bracket: "index", index: 2}}, ""},
+		{"*.ipv4", []selectorStep{{field: "*"}, {field: "ipv4"}}, ""},
+		{"prefixes[?service==CLOUDFRONT]", []selectorStep{{field: "prefixes"}, {bracket: "filter", key: "service", expected: "CLOUDFRONT"}}, ""},
+		{"prefixes[?(@.service == 'CLOUDFRONT')]", []selectorStep{{field: "prefixes"}, {bracket: "filter", key: "service", expected: "CLOUDFRONT"}}, ""},
+		{"prefixes[*", nil, "missing ]"},
+		{"prefixes[?service]", nil, "must be key==value"},
+		{"prefixes[first]", nil, "unsupported bracket [first]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			got, err := parseSelector(tt.selector)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseSelector(%q) error = %v, want %q", tt.selector, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSelector(%q) = %+v, want %+v", tt.selector, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectJSON(t *testing.T) {
+	const doc = `{
+		"syncToken": "1729324960",
+		"prefixes": [
+			{"ip_prefix": "13.32.0.0/15", "service": "CLOUDFRONT"},
+			{"ip_prefix": "3.5.140.0/22", "service": "AMAZON"},
+			{"ip_prefix": "52.46.0.0/18", "service": "cloudfront"}
+		],
+		"addresses": {"b": ["192.0.2.0/24"], "a": ["198.51.100.0/24"]}
+	}`
+	var decoded any
+	if err := json.Unmarshal([]byte(doc), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		selector string
+		want     []any
+	}{
+		{"syncToken", []any{"1729324960"}},
+		{"$.syncToken", []any{"1729324960"}},
+		{"prefixes[*].ip_prefix", []any{"13.32.0.0/15", "3.5.140.0/22", "52.46.0.0/18"}},
+		{"prefixes[0].ip_prefix", []any{"13.32.0.0/15"}},
+		{"prefixes[-1].ip_prefix", []any{"52.46.0.0/18"}},
+		{"prefixes[3].ip_prefix", nil},
+		{"prefixes[-4].ip_prefix", nil},
+		{"prefixes[?service==CLOUDFRONT].ip_prefix", []any{"13.32.0.0/15", "52.46.0.0/18"}},
+		{"prefixes[?service==S3].ip_prefix", nil},
+		// * takes the values of an object ordered by key.
+		{"addresses.*[0]", []any{"198.51.100.0/24", "192.0.2.0/24"}},
+		{"addresses[*][*]", []any{"198.51.100.0/24", "192.0.2.0/24"}},
+		{"missing.field", nil},
+		{"syncToken[0]", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			got, err := selectJSON(decoded, tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectJSON(%q) = %v, want %v", tt.selector, got, tt.want)
+			}
+		})
+	}
+	if _, err := selectJSON(decoded, "prefixes[0"); err == nil {
+		t.Error("selectJSON() with an unterminated [ did not fail")
+	}
+}
+
+func TestParseText(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "one per line",
+			body: "192.0.2.0/24\n2001:db8::/32\n198.51.100.7\n",
+			want: []string{"192.0.2.0/24", "2001:db8::/32", "198.51.100.7/32"},
+		},
+		{
+			name: "labels",
+			body: "address:192.0.2.1 ip:198.51.100.1, ipv6:2001:db8::1",
+			want: []string{"192.0.2.1/32", "198.51.100.1/32", "2001:db8::1/128"},
+		},
+		{
+			name: "address range",
+			body: "10.0.0.1-10.0.0.5\n",
+			want: []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31"},
+		},
+		{
+			name: "prose",
+			body: "Our servers are in 192.0.2.0/24 and ::ffff:0:0/96. Updated 2024-10-19, version 1.2.",
+			want: []string{"192.0.2.0/24", "::ffff:0.0.0.0/96"},
+		},
+		{
+			name: "json and csv",
+			body: `{"ips": ["192.0.2.0/24","198.51.100.0/24"]}` + "\nname,203.0.113.0/24,eu\n",
+			want: []string{"192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24"},
+		},
+		{
+			name: "no address",
+			body: "deadbeef cafe:babe abc-def 1.2.3 10.0.0.5-10.0.0.1",
+		},
+		{
+			name:    "pattern",
+			body:    "# comment 192.0.2.0/24\n198.51.100.0/24 eu\n203.0.113.0/24 us\n",
+			pattern: `(?m)^(\S+/\d+)`,
+			want:    []string{"198.51.100.0/24", "203.0.113.0/24"},
+		},
+		{
+			name:    "pattern without a group",
+			body:    "range=10.0.0.1-10.0.0.2;range=bogus;",
+			pattern: `[^=;]+-[^=;]+`,
+			want:    []string{"10.0.0.1/32", "10.0.0.2/32"},
+		},
+		{
+			name:    "invalid pattern",
+			body:    "192.0.2.0/24",
+			pattern: `(`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseText([]byte(tt.body), tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseText() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenericParse(t *testing.T) {
+	body := []byte(`{"prefixes": [{"ipv4Prefix": "192.0.2.0/24"}, {"ipv6Prefix": "2001:db8::/32"}, {"ipv4Prefix": "192.0.2.0/24"}]}`)
+	site := common.Site{Name: "Test", Selectors: []string{"prefixes[*].ipv4Prefix", "prefixes[*].ipv6Prefix"}}
+	got, err := generic{}.Parse(site, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"192.0.2.0/24", "2001:db8::/32"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %v, want %v", got, want)
+	}
+	site.Selectors = []string{"prefixes[*].ip_prefix"}
+	if _, err = (generic{}).Parse(site, body); err == nil {
+		t.Error("Parse() without any match did not fail")
+	}
+}
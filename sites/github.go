@@ -0,0 +1,57 @@
+package sites
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/csyezheng/ip-scanner/common"
+	"log/slog"
+	"net/netip"
+	"sort"
+)
+
+// github reads https://api.github.com/meta. Site.Services selects the keys to keep,
+// such as web, api, git or pages. Every key holding ranges is kept when it is empty.
+type github struct {
+	httpFetcher
+}
+
+func init() {
+	Register(github{httpFetcher{accept: "application/vnd.github+json"}})
+}
+
+func (github) Name() string {
+	return "GitHub"
+}
+
+func (github) Parse(site common.Site, body []byte) ([]string, error) {
+	var res map[string]json.RawMessage
+	if err := json.Unmarshal(body, &res); err != nil {
+		slog.Error("Failed to decode github JSON", "error", err)
+		return nil, err
+	}
+	keys := make([]string, 0, len(res))
+	for key := range res {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var ipCIDRs []string
+	for _, key := range keys {
+		if !matchFilter(site.Services, key) {
+			continue
+		}
+		// Not every key holds a list of ranges, e.g. ssh_keys, ssh_key_fingerprints and domains.
+		var values []string
+		if err := json.Unmarshal(res[key], &values); err != nil {
+			continue
+		}
+		for _, value := range values {
+			if _, err := netip.ParsePrefix(value); err == nil {
+				ipCIDRs = append(ipCIDRs, value)
+			}
+		}
+	}
+	if len(ipCIDRs) == 0 {
+		return nil, fmt.Errorf("no github meta ranges match keys %v", site.Services)
+	}
+	return dedupe(ipCIDRs), nil
+}
@@ -0,0 +1,162 @@
+package sites
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/csyezheng/ip-scanner/common"
+	"log/slog"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FetchMeta is what is remembered about the last fetch of a site's IP ranges.
+// It is stored as JSON next to the IPRangesFile.
+type FetchMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Version      string    `json:"version,omitempty"`  // syncToken, etag or similar found in the document itself
+	Settings     string    `json:"settings,omitempty"` // fetchSettings of the site when it was fetched
+	FetchedAt    time.Time `json:"fetchedAt"`
+	Count        int       `json:"count"`
+}
+
+// fetchSettings hashes the settings of site that select what is parsed out of the document,
+// so a change to them fetches and parses it again even if it did not change.
+func fetchSettings(site common.Site) string {
+	data, _ := json.Marshal([]any{site.Provider, site.Services, site.Regions, site.Selectors, site.Pattern, site.VersionSelector})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+func fetchMetaPath(site common.Site) string {
+	return site.IPRangesFile + ".meta.json"
+}
+
+// loadFetchMeta returns the metadata of the previous fetch, or the zero FetchMeta when
+// there is none, the ranges file it belongs to is gone, or it was fetched from another
+// URL or with other settings.
+func loadFetchMeta(site common.Site) FetchMeta {
+	var meta FetchMeta
+	if _, err := os.Stat(site.IPRangesFile); err != nil {
+		return meta
+	}
+	data, err := os.ReadFile(fetchMetaPath(site))
+	if err != nil {
+		return meta
+	}
+	if err = json.Unmarshal(data, &meta); err != nil {
+		slog.Warn("ignore invalid fetch metadata", "file", fetchMetaPath(site), "error", err)
+		return FetchMeta{}
+	}
+	if meta.URL != site.IPRangesAPI || meta.Settings != fetchSettings(site) {
+		return FetchMeta{}
+	}
+	return meta
+}
+
+func saveFetchMeta(site common.Site, meta FetchMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return common.WriteFileAtomic(fetchMetaPath(site), append(data, '\n'), 0644)
+}
+
+// readIPRanges reads the prefixes of a ranges file, skipping blank lines and comments.
+func readIPRanges(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ipCIDRs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ipCIDRs = append(ipCIDRs, line)
+	}
+	return ipCIDRs, scanner.Err()
+}
+
+// printDiff prints the prefixes added to and removed from the ranges file.
+func printDiff(oldCIDRs, newCIDRs []string) {
+	added, removed := diffIPRanges(oldCIDRs, newCIDRs)
+	if len(added) == 0 && len(removed) == 0 {
+		slog.Info("IP ranges are the same as before")
+		return
+	}
+	slog.Info("IP ranges changed:", "added", len(added), "removed", len(removed))
+	for _, cidr := range added {
+		fmt.Printf("+ %s\n", cidr)
+	}
+	for _, cidr := range removed {
+		fmt.Printf("- %s\n", cidr)
+	}
+}
+
+func diffIPRanges(oldCIDRs, newCIDRs []string) (added, removed []string) {
+	oldSet := make(map[string]bool, len(oldCIDRs))
+	for _, cidr := range oldCIDRs {
+		oldSet[cidr] = true
+	}
+	newSet := make(map[string]bool, len(newCIDRs))
+	for _, cidr := range newCIDRs {
+		newSet[cidr] = true
+		if !oldSet[cidr] {
+			added = append(added, cidr)
+		}
+	}
+	for _, cidr := range oldCIDRs {
+		if !newSet[cidr] {
+			removed = append(removed, cidr)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// warnStaleCustomRanges warns about prefixes of the CustomIPRangesFile that are no
+// longer covered by the published ranges.
+func warnStaleCustomRanges(site common.Site, ipCIDRs []string) {
+	entries, err := common.ParseRangesFile(site.CustomIPRangesFile)
+	if err != nil {
+		return
+	}
+	var published []netip.Prefix
+	for _, cidr := range ipCIDRs {
+		if p, err := netip.ParsePrefix(cidr); err == nil {
+			published = append(published, p.Masked())
+		}
+	}
+	for _, entry := range entries {
+		if entry.Exclude {
+			continue
+		}
+		for _, p := range entry.Prefixes {
+			if !coveredBy(p, published) {
+				slog.Warn("custom ip range is no longer published, the custom ranges file may be stale",
+					"CIDR", p.String(), "file", entry.File, "line", entry.Line)
+			}
+		}
+	}
+}
+
+func coveredBy(p netip.Prefix, prefixes []netip.Prefix) bool {
+	for _, q := range prefixes {
+		if q.Bits() <= p.Bits() && q.Contains(p.Addr()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package sites
+
+import (
+	"github.com/csyezheng/ip-scanner/common"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadFetchMeta(t *testing.T) {
+	dir := t.TempDir()
+	site := common.Site{
+		IPRangesAPI:  "https://ip-ranges.amazonaws.com/ip-ranges.json",
+		IPRangesFile: filepath.Join(dir, "aws.txt"),
+		Services:     []string{"CLOUDFRONT"},
+	}
+	if err := os.WriteFile(site.IPRangesFile, []byte("13.32.0.0/15\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta := FetchMeta{URL: site.IPRangesAPI, Settings: fetchSettings(site), Version: "1729324960", FetchedAt: time.Now()}
+	if err := saveFetchMeta(site, meta); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadFetchMeta(site); got.Version != meta.Version {
+		t.Errorf("loadFetchMeta() version = %q, want %q", got.Version, meta.Version)
+	}
+	changed := []struct {
+		name   string
+		change func(site *common.Site)
+	}{
+		{"url", func(site *common.Site) { site.IPRangesAPI = "https://example.com/ranges.json" }},
+		{"services", func(site *common.Site) { site.Services = []string{"CLOUDFRONT", "ROUTE53"} }},
+		{"regions", func(site *common.Site) { site.Regions = []string{"GLOBAL"} }},
+		{"selectors", func(site *common.Site) { site.Selectors = []string{"prefixes[*].ip_prefix"} }},
+		{"pattern", func(site *common.Site) { site.Pattern = `\S+/\d+` }},
+	}
+	for _, tt := range changed {
+		t.Run(tt.name, func(t *testing.T) {
+			other := site
+			tt.change(&other)
+			if got := loadFetchMeta(other); got != (FetchMeta{}) {
+				t.Errorf("loadFetchMeta() = %+v after changing %s, want none", got, tt.name)
+			}
+		})
+	}
+}
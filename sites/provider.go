@@ -0,0 +1,199 @@
+package sites
+
+import (
+	"fmt"
+	"github.com/csyezheng/ip-scanner/common"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider knows where a site publishes its IP ranges and how to read them.
+type Provider interface {
+	// Name is the key the provider is registered under. It is matched against
+	// Site.Provider, or Site.Name when no provider is configured.
+	Name() string
+	// Fetch downloads the raw IP ranges document published for the site. previous
+	// describes the last successful fetch, so unchanged documents need not be downloaded.
+	Fetch(site common.Site, previous FetchMeta) (*Document, error)
+	// Parse extracts the CIDR prefixes from a raw IP ranges document.
+	Parse(site common.Site, body []byte) ([]string, error)
+}
+
+// Versioner is implemented by providers whose documents carry their own version,
+// such as Google's syncToken or Cloudflare's etag, so unchanged lists can be skipped
+// even when the server does not support conditional requests.
+type Versioner interface {
+	Version(site common.Site, body []byte) string
+}
+
+// Document is a fetched IP ranges document.
+type Document struct {
+	Body         []byte
+	ETag         string
+	LastModified string
+	// NotModified is set when the server answered that the document did not change
+	// since the previous fetch, Body is empty then.
+	NotModified bool
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]Provider)
+)
+
+// Register makes a provider available to FetchIPRanges. It panics if a provider
+// with the same name is registered twice, so it is meant to be called from init.
+func Register(provider Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	name := provider.Name()
+	if _, dup := providers[name]; dup {
+		panic("sites: Register called twice for provider " + name)
+	}
+	providers[name] = provider
+}
+
+// Providers returns the sorted names of the registered providers.
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the provider of site, keyed by Site.Provider or, if that is empty, Site.Name.
+func Lookup(site common.Site) (Provider, error) {
+	name := site.Provider
+	if name == "" {
+		name = site.Name
+	}
+	providersMu.RLock()
+	provider, ok := providers[name]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no ip ranges provider %q for site %s, available: %s",
+			name, site.Name, strings.Join(Providers(), ", "))
+	}
+	return provider, nil
+}
+
+// FetchIPRanges fetches the latest IP ranges of the configured site with its provider
+// and saves them in the site's IPRangesFile. Unless force is set, nothing is rewritten
+// when the published list did not change since the previous fetch.
+func FetchIPRanges(config *common.Config, force bool) error {
+	site := common.RetrieveSiteCfg(config).Site
+	if site.IPRangesAPI == "" {
+		return fmt.Errorf("site %s has no IPRangesAPI to fetch the ip ranges from", site.Name)
+	}
+	provider, err := Lookup(site)
+	if err != nil {
+		return err
+	}
+	return fetchWith(provider, site, force)
+}
+
+func fetchWith(provider Provider, site common.Site, force bool) (err error) {
+	defer func() { common.RecordFetch(site.Name, err) }()
+	dest := site.IPRangesFile
+	var previous FetchMeta
+	if !force {
+		previous = loadFetchMeta(site)
+	}
+	doc, err := provider.Fetch(site, previous)
+	if err != nil {
+		return err
+	}
+	if doc.NotModified {
+		slog.Info("IP ranges not modified since the last fetch", "file", dest, "fetched", previous.FetchedAt)
+		return nil
+	}
+	meta := FetchMeta{
+		URL:          site.IPRangesAPI,
+		Settings:     fetchSettings(site),
+		ETag:         doc.ETag,
+		LastModified: doc.LastModified,
+		FetchedAt:    time.Now(),
+	}
+	if versioner, ok := provider.(Versioner); ok {
+		meta.Version = versioner.Version(site, doc.Body)
+		if meta.Version != "" && meta.Version == previous.Version {
+			slog.Info("IP ranges not changed since the last fetch", "file", dest, "version", meta.Version)
+			return nil
+		}
+	}
+	ipCIDRs, err := provider.Parse(site, doc.Body)
+	if err != nil {
+		return err
+	}
+	if err = validateIPRanges(ipCIDRs); err != nil {
+		return fmt.Errorf("%s returned invalid ip ranges: %w", site.IPRangesAPI, err)
+	}
+	oldCIDRs, err := readIPRanges(dest)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = saveIPRanges(dest, ipCIDRs); err != nil {
+		return err
+	}
+	meta.Count = len(ipCIDRs)
+	if err = saveFetchMeta(site, meta); err != nil {
+		slog.Warn("save fetch metadata failed", "error", err)
+	}
+	slog.Info("fetch success, the latest IP segment has been saved in", "file", dest,
+		"provider", provider.Name(), "count", len(ipCIDRs))
+	printDiff(oldCIDRs, ipCIDRs)
+	warnStaleCustomRanges(site, ipCIDRs)
+	return nil
+}
+
+// httpFetcher implements Provider.Fetch with a GET request to Site.IPRangesAPI,
+// made conditional with the ETag and Last-Modified of the previous fetch.
+// Providers embed it and only have to implement Name and Parse.
+type httpFetcher struct {
+	accept string
+}
+
+func (f httpFetcher) Fetch(site common.Site, previous FetchMeta) (*Document, error) {
+	return fetchURL(site.IPRangesAPI, f.accept, previous)
+}
+
+// saveIPRanges replaces dest with the prefixes, one per line. The file is written to a
+// temporary file first, so a failed write never leaves a truncated ranges file behind.
+func saveIPRanges(dest string, ipCIDRs []string) error {
+	return common.WriteFileAtomic(dest, []byte(strings.Join(ipCIDRs, "\n")+"\n"), 0644)
+}
+
+// matchFilter reports whether value is one of filters, ignoring case. An empty filter matches everything.
+func matchFilter(filters []string, value string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, filter := range filters {
+		if strings.EqualFold(filter, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupe removes repeated prefixes, keeping the first occurrence.
+func dedupe(ipCIDRs []string) []string {
+	seen := make(map[string]bool, len(ipCIDRs))
+	result := ipCIDRs[:0]
+	for _, cidr := range ipCIDRs {
+		if seen[cidr] {
+			continue
+		}
+		seen[cidr] = true
+		result = append(result, cidr)
+	}
+	return result
+}
@@ -0,0 +1,166 @@
+package sites
+
+import (
+	"github.com/csyezheng/ip-scanner/common"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		file     string
+		site     common.Site
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "aws all",
+			provider: aws{},
+			file:     "aws.json",
+			want:     []string{"3.5.140.0/22", "13.32.0.0/15", "52.46.0.0/18", "2600:9000::/28", "2600:1f00:6000::/40"},
+		},
+		{
+			name:     "aws service",
+			provider: aws{},
+			file:     "aws.json",
+			site:     common.Site{Services: []string{"cloudfront"}},
+			want:     []string{"13.32.0.0/15", "52.46.0.0/18", "2600:9000::/28"},
+		},
+		{
+			name:     "aws service and region",
+			provider: aws{},
+			file:     "aws.json",
+			site:     common.Site{Services: []string{"CLOUDFRONT"}, Regions: []string{"GLOBAL"}},
+			want:     []string{"13.32.0.0/15", "2600:9000::/28"},
+		},
+		{
+			name:     "aws no match",
+			provider: aws{},
+			file:     "aws.json",
+			site:     common.Site{Services: []string{"EC2"}},
+			wantErr:  true,
+		},
+		{
+			name:     "fastly",
+			provider: fastly{},
+			file:     "fastly.json",
+			want:     []string{"23.235.32.0/20", "43.249.72.0/22", "151.101.0.0/16", "2a04:4e40::/32", "2a04:4e42::/32"},
+		},
+		{
+			name:     "github all",
+			provider: github{},
+			file:     "github.json",
+			want: []string{"140.82.112.0/20", "143.55.64.0/20", "192.30.252.0/22", "185.199.108.0/22", "2a0a:a440::/29",
+				"2606:50c0:8000::/64"},
+		},
+		{
+			name:     "github services",
+			provider: github{},
+			file:     "github.json",
+			site:     common.Site{Services: []string{"pages", "api"}},
+			want:     []string{"140.82.112.0/20", "143.55.64.0/20", "185.199.108.0/22", "2606:50c0:8000::/64"},
+		},
+		{
+			name:     "github no ranges",
+			provider: github{},
+			file:     "github.json",
+			site:     common.Site{Services: []string{"ssh_keys"}},
+			wantErr:  true,
+		},
+		{
+			name:     "azure all",
+			provider: azure{},
+			file:     "azure.json",
+			want: []string{"13.107.208.0/24", "13.107.213.0/24", "2620:1ec:4::/46", "13.69.0.0/17", "2603:1020:200::/46",
+				"13.68.128.0/17"},
+		},
+		{
+			name:     "azure service",
+			provider: azure{},
+			file:     "azure.json",
+			site:     common.Site{Services: []string{"AzureFrontDoor.Frontend"}},
+			want:     []string{"13.107.208.0/24", "13.107.213.0/24", "2620:1ec:4::/46"},
+		},
+		{
+			name:     "azure region",
+			provider: azure{},
+			file:     "azure.json",
+			site:     common.Site{Regions: []string{"westeurope"}},
+			want:     []string{"13.69.0.0/17", "2603:1020:200::/46"},
+		},
+		{
+			name:     "azure no match",
+			provider: azure{},
+			file:     "azure.json",
+			site:     common.Site{Services: []string{"AzureFrontDoor.Frontend"}, Regions: []string{"eastus"}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.provider.Parse(tt.site, readTestdata(t, tt.file))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+			if err = validateIPRanges(got); err != nil {
+				t.Errorf("Parse() returned invalid ranges: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseInvalidJSON(t *testing.T) {
+	for _, provider := range []Provider{aws{}, fastly{}, github{}, azure{}} {
+		if _, err := provider.Parse(common.Site{}, []byte("<html>")); err == nil {
+			t.Errorf("%s: Parse() of invalid JSON succeeded", provider.Name())
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		file     string
+		want     string
+	}{
+		{"aws", aws{}, "aws.json", "1729324960"},
+		{"azure", azure{}, "azure.json", "312"},
+		// Without a version in the document, only the conditional request avoids refetching.
+		{"fastly", fastly{}, "fastly.json", ""},
+		{"github", github{}, "github.json", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if versioner, ok := tt.provider.(Versioner); ok {
+				got = versioner.Version(common.Site{}, readTestdata(t, tt.file))
+			}
+			if got != tt.want {
+				t.Errorf("Version() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if got := (aws{}).Version(common.Site{}, []byte("{")); got != "" {
+		t.Errorf("Version() of invalid JSON = %q, want empty", got)
+	}
+}